@@ -0,0 +1,237 @@
+// Auto-provisioning agent configuring devices as they are plugged in.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/daemon"
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Represents a rule assigning a profile and optionally a 256-byte EEPROM
+// image to matching devices. Empty fields match any device. Serial and
+// path are shell patterns such as "00012*" or "1-1.*". The USB IDs of the
+// profile are programmed only if IDs is set.
+type Rule struct {
+	Name      string       `xml:"name,attr" json:"name" yaml:"name" toml:"name"`
+	VendID    string       `xml:"vendor_id,omitempty" json:"vendor_id,omitempty" yaml:"vendor_id,omitempty" toml:"vendor_id,omitempty"`
+	ProdID    string       `xml:"product_id,omitempty" json:"product_id,omitempty" yaml:"product_id,omitempty" toml:"product_id,omitempty"`
+	Serial    string       `xml:"serial,omitempty" json:"serial,omitempty" yaml:"serial,omitempty" toml:"serial,omitempty"`
+	Path      string       `xml:"path,omitempty" json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	Profile   string       `xml:"profile" json:"profile" yaml:"profile" toml:"profile"`
+	Variables []ProfileVar `xml:"variable,omitempty" json:"variables,omitempty" yaml:"variables,omitempty" toml:"variables,omitempty"`
+	EEPROM    string       `xml:"eeprom,omitempty" json:"eeprom,omitempty" yaml:"eeprom,omitempty" toml:"eeprom,omitempty"`
+	Reset     bool         `xml:"reset,omitempty" json:"reset,omitempty" yaml:"reset,omitempty" toml:"reset,omitempty"`
+	IDs       bool         `xml:"ids,omitempty" json:"ids,omitempty" yaml:"ids,omitempty" toml:"ids,omitempty"`
+}
+
+// Represents the rules file of the agent.
+type Rules struct {
+	XMLName xml.Name `xml:"rules" json:"-" yaml:"-" toml:"-"`
+	Rules   []Rule   `xml:"rule" json:"rules" yaml:"rules" toml:"rules"`
+}
+
+// Represents a rule with its profile loaded.
+type agentRule struct {
+	Rule
+	vid, pid usb.ID
+	assign   assignment
+}
+
+// Reports whether the device matches the rule.
+func (rule *agentRule) match(info usb.DeviceInfo) bool {
+	if rule.vid != 0 && rule.vid != info.VendID {
+		return false
+	}
+	if rule.pid != 0 && rule.pid != info.ProdID {
+		return false
+	}
+	if ok, _ := path.Match(rule.Serial, info.Serial); rule.Serial != "" && !ok {
+		return false
+	}
+	if ok, _ := path.Match(rule.Path, info.Path); rule.Path != "" && !ok {
+		return false
+	}
+	return true
+}
+
+// Loads a rules file and the profiles and EEPROM images it refers to.
+// Paths are relative to the directory of the rules file.
+func loadRules(filename string) ([]*agentRule, error) {
+	rules := new(Rules)
+	if err := util.ImportFile(filename, rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules defined", filename)
+	}
+
+	var loaded []*agentRule
+	for i, r := range rules.Rules {
+		rule := &agentRule{Rule: r}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		var err error
+		if r.VendID != "" {
+			if rule.vid, err = parseID(r.VendID); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", filename, rule.Name, err)
+			}
+		}
+		if r.ProdID != "" {
+			if rule.pid, err = parseID(r.ProdID); err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", filename, rule.Name, err)
+			}
+		}
+
+		for _, pattern := range []string{r.Serial, r.Path} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("%s: %s: invalid pattern %q", filename, rule.Name, pattern)
+			}
+		}
+
+		if r.Profile == "" {
+			return nil, fmt.Errorf("%s: %s: missing profile", filename, rule.Name)
+		}
+
+		rule.assign.file = relPath(filename, r.Profile)
+		rule.assign.ids = r.IDs
+		if rule.assign.profile, err = loadProfile(rule.assign.file); err != nil {
+			return nil, err
+		}
+
+		if r.EEPROM != "" {
+			file := relPath(filename, r.EEPROM)
+			if rule.assign.eeprom, err = ioutil.ReadFile(file); err != nil {
+				return nil, err
+			}
+			if len(rule.assign.eeprom) != usb.EEPROMSize {
+				return nil, fmt.Errorf("%s: EEPROM image must be %d bytes", file, usb.EEPROMSize)
+			}
+		}
+
+		rule.assign.vars = make(map[string]string)
+		for _, v := range r.Variables {
+			rule.assign.vars[v.Name] = v.Value
+		}
+
+		loaded = append(loaded, rule)
+	}
+
+	return loaded, nil
+}
+
+// Returns the first rule matching the device, or nil.
+func matchRule(rules []*agentRule, info usb.DeviceInfo) *agentRule {
+	for _, rule := range rules {
+		if rule.match(info) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Returns the USB IDs to watch: the known IDs and those named in rules.
+func agentIDs(rules []*agentRule) [][2]usb.ID {
+	ids := knownIDs()
+	for _, rule := range rules {
+		if rule.vid == 0 || rule.pid == 0 {
+			continue
+		}
+
+		id, known := [2]usb.ID{rule.vid, rule.pid}, false
+		for _, k := range ids {
+			known = known || k == id
+		}
+		if !known {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Watches for attached devices and applies the profile of the first
+// matching rule to each of them until SIGINT or SIGTERM is received.
+func runAgent(backend string, rules []*agentRule, interval time.Duration) {
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Info("Shutting down", util.F("signal", sig))
+		daemon.SdNotify(false, daemon.SdNotifyStopping)
+		close(stop)
+	}()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		busy = make(map[string]bool)
+	)
+
+	events := usb.WatchIDs(backend, agentIDs(rules), interval, stop)
+	daemon.SdNotify(false, daemon.SdNotifyReady)
+	logger.Info("Watching for devices", util.F("rules", len(rules)))
+
+	for event := range events {
+		info := event.Device
+		log := logger.With(util.F("serial", info.Serial), util.F("path", info.Path))
+		if !event.Attached {
+			log.Info("Device detached")
+			continue
+		}
+
+		rule := matchRule(rules, info)
+		if rule == nil {
+			log.Info("Device attached, no matching rule")
+			continue
+		}
+
+		// a device being configured is skipped until it is done
+		key := info.Path + "/" + info.Serial
+		mu.Lock()
+		if busy[key] {
+			mu.Unlock()
+			continue
+		}
+		busy[key] = true
+		mu.Unlock()
+
+		log.Info("Device attached, applying profile", util.F("rule", rule.Name), util.F("profile", rule.assign.file))
+
+		wg.Add(1)
+		go func(info usb.DeviceInfo, rule *agentRule, log *util.Logger) {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(busy, key)
+				mu.Unlock()
+			}()
+
+			result := provisionDevice(backend, info, rule.assign, rule.Reset)
+			if result.Status != statusOK {
+				log.Error("Provisioning failed", util.F("rule", rule.Name), util.F("error", result.Error))
+				return
+			}
+
+			for _, change := range result.Changes {
+				log.Info("Field changed", util.F("field", change.Field), util.F("old", change.Old), util.F("new", change.New))
+			}
+			log.Info("Device configured and verified", util.F("rule", rule.Name), util.F("changes", len(result.Changes)))
+		}(info, rule, log)
+	}
+
+	wg.Wait()
+}
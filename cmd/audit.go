@@ -0,0 +1,123 @@
+// Drift detection comparing devices with their assigned profiles.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// define audit result states besides statusOK and statusFailed
+const (
+	statusDrift      = "drift"
+	statusUnassigned = "unassigned"
+)
+
+// Represents the outcome of auditing a single device. Old holds the
+// assigned and New the actual value of each drifted field.
+type AuditResult struct {
+	Serial  string       `json:"serial"`
+	Path    string       `json:"path"`
+	VendID  string       `json:"vendor_id"`
+	ProdID  string       `json:"product_id"`
+	Rule    string       `json:"rule,omitempty"`
+	Profile string       `json:"profile,omitempty"`
+	Status  string       `json:"status"`
+	Drift   []usb.Change `json:"drift,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// Compares a device with the configuration assigned to it. The device is
+// only read: configuration with READ_ALL, EEPROM and string descriptors.
+func auditDevice(backend string, info usb.DeviceInfo, a assignment) ([]usb.Change, error) {
+	dev, err := openInfo(backend, info)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close()
+
+	current, err := readConf(dev)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned, err := profileConf(a.profile, a.vars, current)
+	if err != nil {
+		return nil, err
+	}
+
+	want := *dev.Data
+	if err := encodeConf(assigned, &want); err != nil {
+		return nil, err
+	}
+
+	drift := usb.DiffConfig(&want, dev.Data)
+
+	add := func(field, o, n string) {
+		if o != n {
+			drift = append(drift, usb.Change{Field: field, Old: o, New: n})
+		}
+	}
+
+	add("Manufacturer", assigned.Manufact, current.Manufact)
+	add("Product", assigned.Product, current.Product)
+
+	vid, _ := parseID(assigned.VendID)
+	pid, _ := parseID(assigned.ProdID)
+	wantVID, wantPID := util.UintToStr(uint16(vid), uint16(pid))
+	add("USB ID", wantVID+":"+wantPID, current.VendID+":"+current.ProdID)
+
+	if a.eeprom != nil {
+		image, err := dev.DumpEEPROM()
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, diffEEPROM(a.eeprom, image)...)
+	}
+
+	return drift, nil
+}
+
+// Audits all devices against the rules. Devices matching no rule are
+// reported as unassigned.
+func auditAll(backend string, list []usb.DeviceInfo, rules []*agentRule) []AuditResult {
+	results := make([]AuditResult, len(list))
+
+	for i, info := range list {
+		r := &results[i]
+		r.Serial, r.Path = info.Serial, info.Path
+		r.VendID, r.ProdID = util.UintToStr(uint16(info.VendID), uint16(info.ProdID))
+
+		rule := matchRule(rules, info)
+		if rule == nil {
+			r.Status = statusUnassigned
+			continue
+		}
+		r.Rule, r.Profile = rule.Name, rule.assign.file
+
+		drift, err := auditDevice(backend, info, rule.assign)
+		switch {
+		case err != nil:
+			r.Status, r.Error = statusFailed, err.Error()
+		case len(drift) > 0:
+			r.Status, r.Drift = statusDrift, drift
+		default:
+			r.Status = statusOK
+		}
+	}
+
+	return results
+}
+
+// Prints audit results for humans, one device per line followed by its
+// drifted fields.
+func printAudit(results []AuditResult) {
+	for _, r := range results {
+		fmt.Printf("%-10s %-20s %-10s %s %s\n", r.Path, r.Serial, r.Status, r.Rule, r.Error)
+		for _, change := range r.Drift {
+			fmt.Printf("    %-18s assigned %s, found %s\n", change.Field, change.Old, change.New)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Device backup archives.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Version of the backup archive format written by this release.
+const backupVersion = 1
+
+// Represents bytes stored as a hex string.
+type hexBytes []byte
+
+// Encodes the bytes as hex string.
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// Decodes the bytes from a hex string.
+func (b *hexBytes) UnmarshalText(text []byte) error {
+	buf, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = buf
+	return nil
+}
+
+// Represents the USB descriptors of a backed up device.
+type BackupDevice struct {
+	Backend      string `json:"backend"`
+	Path         string `json:"path,omitempty"`
+	VendID       string `json:"vendor_id"`
+	ProdID       string `json:"product_id"`
+	Manufacturer string `json:"manufacturer"`
+	Product      string `json:"product"`
+	Serial       string `json:"serial"`
+	Release      string `json:"release,omitempty"`
+}
+
+// Represents a backup archive holding everything stored on a device.
+type Backup struct {
+	Version int          `json:"version"`
+	Created string       `json:"created"`
+	Device  BackupDevice `json:"device"`
+
+	// READ_ALL response bytes.
+	Config hexBytes `json:"config"`
+
+	// Full user EEPROM image.
+	EEPROM hexBytes `json:"eeprom"`
+}
+
+// Reads configuration, EEPROM and descriptors of the open device.
+func readBackup() (*Backup, error) {
+	data, err := micro.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := micro.DumpEEPROM()
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &Backup{
+		Version: backupVersion,
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Config:  data.MarshalBytes(),
+		EEPROM:  image,
+	}
+
+	dev := &backup.Device
+	dev.Backend = devBackend
+	dev.VendID, dev.ProdID = util.UintToStr(uint16(micro.VendID), uint16(micro.ProdID))
+
+	if dev.Manufacturer, err = micro.ReadManufacturer(); err != nil {
+		return nil, err
+	}
+	if dev.Product, err = micro.ReadProduct(); err != nil {
+		return nil, err
+	}
+	if dev.Serial, err = micro.ReadSerial(); err != nil {
+		return nil, err
+	}
+
+	// port path and release are informational, a failed scan leaves them empty
+	if list, err := listKnown(devBackend); err == nil {
+		for _, info := range list {
+			if info.Serial == dev.Serial {
+				dev.Path, dev.Release = info.Path, info.Release
+			}
+		}
+	}
+
+	return backup, nil
+}
+
+// Saves a backup archive as JSON.
+func saveBackup(file string, backup *Backup) error {
+	return util.ExportJSON(file, backup)
+}
+
+// Loads a backup archive and checks its version and contents.
+func loadBackup(file string) (*Backup, error) {
+	backup := new(Backup)
+	if err := util.ImportJSON(file, backup); err != nil {
+		return nil, err
+	}
+
+	if backup.Version < 1 || backup.Version > backupVersion {
+		return nil, fmt.Errorf("%s: unsupported backup version %d", file, backup.Version)
+	}
+
+	if len(backup.Config) != usb.DataSize {
+		return nil, fmt.Errorf("%s: config must be %d bytes, got %d", file, usb.DataSize, len(backup.Config))
+	}
+
+	if len(backup.EEPROM) != usb.EEPROMSize {
+		return nil, fmt.Errorf("%s: EEPROM must be %d bytes, got %d", file, usb.EEPROMSize, len(backup.EEPROM))
+	}
+
+	if _, err := backup.ids(); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	for _, str := range []string{backup.Device.Manufacturer, backup.Device.Product} {
+		if err := usb.ValidateString(str); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	return backup, nil
+}
+
+// Returns the configuration stored in the backup.
+func (backup *Backup) data() *usb.Data {
+	data := new(usb.Data)
+	data.UnmarshalBytes(backup.Config)
+	return data
+}
+
+// Returns the USB Vendor/Product IDs stored in the backup.
+func (backup *Backup) ids() ([2]usb.ID, error) {
+	vid, err := parseID(backup.Device.VendID)
+	if err != nil {
+		return [2]usb.ID{}, err
+	}
+
+	pid, err := parseID(backup.Device.ProdID)
+	if err != nil {
+		return [2]usb.ID{}, err
+	}
+
+	return [2]usb.ID{vid, pid}, nil
+}
+
+// Compares two EEPROM images byte by byte.
+func diffEEPROM(old, new []byte) []usb.Change {
+	var changes []usb.Change
+	for addr := range new {
+		if old[addr] != new[addr] {
+			changes = append(changes, usb.Change{
+				Field: fmt.Sprintf("EEPROM[0x%02X]", addr),
+				Old:   fmt.Sprintf("0x%02X", old[addr]),
+				New:   fmt.Sprintf("0x%02X", new[addr]),
+			})
+		}
+	}
+	return changes
+}
+
+// Compares two backups field by field. Serial number, release number and
+// port path are not compared since they can not be written.
+func diffBackup(old, new *Backup) []usb.Change {
+	changes := usb.DiffConfig(old.data(), new.data())
+	changes = append(changes, diffEEPROM(old.EEPROM, new.EEPROM)...)
+
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, usb.Change{Field: field, Old: o, New: n})
+		}
+	}
+
+	add("Manufacturer", old.Device.Manufacturer, new.Device.Manufacturer)
+	add("Product", old.Device.Product, new.Device.Product)
+
+	oldIDs, _ := old.ids()
+	newIDs, _ := new.ids()
+	if oldIDs != newIDs {
+		add("USB ID", old.Device.VendID+":"+old.Device.ProdID, new.Device.VendID+":"+new.Device.ProdID)
+	}
+
+	return changes
+}
+
+// Writes the parts of a backup that differ from the current device state
+// and verifies them. Reports whether USB descriptors were written, which
+// take effect after the device is reset.
+func restoreBackup(current, backup *Backup) (bool, error) {
+	data := backup.data()
+	if len(usb.DiffConfig(current.data(), data)) > 0 {
+		micro.Data.IO_Bmap = data.IO_Bmap
+		micro.Data.Alt_Pins = data.Alt_Pins
+		micro.Data.IO_Default = data.IO_Default
+		micro.Data.Alt_Opts = data.Alt_Opts
+		micro.Data.Baud_Rate_H = data.Baud_Rate_H
+		micro.Data.Baud_Rate_L = data.Baud_Rate_L
+
+		if err := micro.WriteConfig(); err != nil {
+			return false, err
+		}
+	}
+
+	// write each run of differing EEPROM bytes at once
+	for addr := 0; addr < usb.EEPROMSize; addr++ {
+		end := addr
+		for end < usb.EEPROMSize && current.EEPROM[end] != backup.EEPROM[end] {
+			end++
+		}
+		if end > addr {
+			if err := micro.WriteEEPROMRange(addr, backup.EEPROM[addr:end]); err != nil {
+				return false, err
+			}
+			addr = end
+		}
+	}
+
+	conf.Manufact, conf.Product = backup.Device.Manufacturer, backup.Device.Product
+	descriptors, err := storeStrings()
+	if err != nil {
+		return descriptors, err
+	}
+
+	currentIDs, _ := current.ids()
+	ids, _ := backup.ids()
+	if ids != currentIDs {
+		if err := programIDs(micro, ids[0], ids[1]); err != nil {
+			return descriptors, err
+		}
+		descriptors = true
+	}
+
+	return descriptors, nil
+}
@@ -0,0 +1,1047 @@
+// Headless command line interface.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// define exit codes of the command line interface
+const (
+	exitOK           = 0
+	exitFail         = 1
+	exitUsage        = 2
+	exitNotFound     = 3
+	exitPermission   = 4
+	exitBusy         = 5
+	exitDisconnected = 6
+	exitTimeout      = 7
+	exitProtocol     = 8
+	exitDrift        = 9
+	exitMismatch     = 10
+)
+
+const usage = `Usage: microconfig [options] <command> [arguments]
+
+Without a command the GTK configurator is started.
+
+Commands:
+  list                    list connected devices
+  info                    show USB IDs and string descriptors
+  read [-raw]             show device configuration
+  configure [-dry-run] [flags]
+                          change device configuration
+  set [-dry-run] NAME=VALUE...
+                          change only the named fields, keeping the rest
+                          of the device configuration as read
+  usbid [-yes] [-reset] VID PID
+                          program new USB Vendor/Product IDs
+  strings [-reset] [-manufacturer STR] [-product STR]
+                          write manufacturer/product string descriptors
+  gpio                    show live GPIO pin states
+  gpio set|clear|toggle PINS
+                          drive output pins, PINS is a mask (0x09)
+                          or a pin list (gp0,gp3)
+  gpio write PINS VAL     drive masked pins to the levels in VAL
+  eeprom read ADDR [LEN]  read bytes from user EEPROM
+  eeprom write ADDR VAL.. write and verify bytes of user EEPROM
+  eeprom dump [FILE]      save the 256-byte EEPROM image, or hex dump it
+  eeprom load FILE        write a 256-byte EEPROM image
+  provision [flags] FILE  apply a profile to every connected device
+  agent [-interval DUR] RULES
+                          configure devices matching the rules as they are
+                          plugged in, until SIGINT or SIGTERM
+  audit [-json] RULES     compare every device with the profile assigned
+                          by the rules, exit with status 9 on drift
+  verify [-serial X] [-path P] [-var NAME=VALUE]... PROFILE
+                          check every profile field against the device
+                          without writing, exit with status 10 on mismatch
+  backup FILE             save configuration, EEPROM, USB IDs and strings
+  restore [-force] [-reset] [-dry-run] FILE
+                          write back what differs from a backup and verify it
+  export FILE             save device configuration to a profile
+  import [-var NAME=VALUE]... [-ids] [-reset] [-dry-run] FILE
+                          apply a profile to the device
+
+Profiles are read and written as XML, JSON, YAML or TOML depending on the
+file extension (.xml, .json, .yaml/.yml, .toml). Manufacturer and product
+strings of a profile may use templates such as "Widget rev {{.revision}}",
+filled from the profile variables, -var flags, serial, vendor_id and product_id.
+The provision command configures all connected devices in parallel, taking
+per-serial profiles and variables from an -overrides file, and writes a
+per-device report with -csv and -json.
+The set command takes baud, io, default, leds, usbcfg, suspend, flow,
+invert (on/off), ledfunc, blink and gp0..gp7 (input/output) fields.
+With -dry-run, configure, set and import print the changed fields and the CONFIGURE
+report that would be sent, without writing anything.
+
+Log records go to stderr, and with -log-file to a file rotated at 1 MiB. With
+-journal-level they are sent to the systemd journal with SERIAL, OPCODE and
+BYTES fields. Each sink has its own level; debug logs every report exchanged
+with the device. The GUI takes the same settings from the MICROCONFIG_LOG_LEVEL,
+MICROCONFIG_LOG_FILE, MICROCONFIG_LOG_FILE_LEVEL, MICROCONFIG_JOURNAL_LEVEL
+and MICROCONFIG_CONSOLE_LEVEL environment variables.
+
+Exit status:
+  0 success, 1 failure, 2 usage error, 3 device not found,
+  4 permission denied, 5 device busy, 6 device disconnected,
+  7 timeout, 8 protocol mismatch, 9 configuration drift,
+  10 device does not match profile
+
+Options:
+`
+
+// Prints error message to stderr and returns given exit code.
+func fail(code int, format string, v ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "microconfig: "+format+"\n", v...)
+	return code
+}
+
+// Returns the exit code for a failed device operation.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, usb.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, usb.ErrPermission):
+		return exitPermission
+	case errors.Is(err, usb.ErrBusy):
+		return exitBusy
+	case errors.Is(err, usb.ErrDisconnected):
+		return exitDisconnected
+	case errors.Is(err, usb.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, usb.ErrProtocol):
+		return exitProtocol
+	}
+	return exitFail
+}
+
+// Prints the error to stderr and returns its exit code.
+// Fields failing verification are printed one per line.
+func failErr(err error) int {
+	var mismatch usb.Mismatch
+	if !errors.As(err, &mismatch) {
+		return fail(exitCode(err), "%v", err)
+	}
+
+	for _, change := range mismatch {
+		fmt.Fprintf(os.Stderr, "microconfig: %s: wrote %s, read %s\n", change.Field, change.Old, change.New)
+	}
+	return fail(exitCode(err), "verification failed after %d retries", usb.WriteRetries)
+}
+
+// Runs the command line interface and returns the exit code.
+func runCLI(args []string) int {
+	flags := flag.NewFlagSet("microconfig", flag.ContinueOnError)
+	backend := flags.String("backend", os.Getenv("MICROCONFIG_BACKEND"), "transport backend: libusb, hidraw or emulator")
+	serial := flags.String("serial", os.Getenv("MICROCONFIG_SERIAL"), "select device by serial number")
+	path := flags.String("path", os.Getenv("MICROCONFIG_PATH"), "select device by USB port path, e.g. 1-1.2")
+	flags.IntVar(&usb.WriteRetries, "retries", usb.WriteRetries, "repeat writes failing verification up to this many times")
+	logCfg := envLogConfig()
+	flags.StringVar(&logCfg.Stderr, "log-level", logCfg.Stderr, "stderr log level: debug, info, warn, error or off (default warn, info for agent)")
+	flags.StringVar(&logCfg.File, "log-file", logCfg.File, "append log records to this file, rotated at 1 MiB")
+	flags.StringVar(&logCfg.FileLevel, "log-file-level", logCfg.FileLevel, "log file level (default debug)")
+	flags.StringVar(&logCfg.Journal, "journal-level", logCfg.Journal, "systemd journal log level (default off)")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	if usb.WriteRetries < 0 {
+		return fail(exitUsage, "invalid retries %d", usb.WriteRetries)
+	}
+
+	cmd, args := flags.Arg(0), flags.Args()[1:]
+
+	stderrLevel := util.LevelWarn
+	if cmd == "agent" {
+		stderrLevel = util.LevelInfo
+	}
+	if err := setupLog(logCfg, stderrLevel); err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	switch cmd {
+	case "list":
+		return listCmd(*backend)
+	case "provision":
+		return provisionCmd(*backend, args)
+	case "agent":
+		return agentCmd(*backend, args)
+	case "audit":
+		return auditCmd(*backend, args)
+	case "verify":
+		return verifyCmd(*backend, usb.Filter{Serial: *serial, Path: *path}, args)
+	}
+
+	switch cmd {
+	case "info", "read", "configure", "set", "usbid", "strings", "gpio", "eeprom", "backup", "restore", "export", "import":
+	default:
+		flags.Usage()
+		return fail(exitUsage, "unknown command %q", cmd)
+	}
+
+	if code := openCLI(*backend, usb.Filter{Serial: *serial, Path: *path}); code != exitOK {
+		return code
+	}
+	// commands resetting the device replace micro with a new handle
+	defer func() {
+		if micro != nil {
+			micro.Close()
+		}
+	}()
+
+	switch cmd {
+	case "info":
+		return infoCmd()
+	case "read":
+		return readCmd(args)
+	case "configure":
+		return configureCmd(args)
+	case "set":
+		return setCmd(args)
+	case "usbid":
+		return usbidCmd(args)
+	case "strings":
+		return stringsCmd(args)
+	case "gpio":
+		return gpioCmd(args)
+	case "eeprom":
+		return eepromCmd(args)
+	case "backup":
+		return backupCmd(args)
+	case "restore":
+		return restoreCmd(args)
+	case "export":
+		return exportCmd(args)
+	case "import":
+		return importCmd(args)
+	}
+
+	return exitOK
+}
+
+// Opens the device matching the filter and returns the exit code.
+// An empty filter is refused when several devices are connected.
+func openCLI(backend string, filter usb.Filter) int {
+	if filter == (usb.Filter{}) {
+		list, err := listKnown(backend)
+		if err != nil {
+			return failErr(err)
+		}
+		if len(list) > 1 {
+			return fail(exitUsage, "%d devices found, select one with -serial or -path", len(list))
+		}
+	}
+
+	if err := openDevice(backend, filter); err != nil {
+		return failErr(err)
+	}
+	logDevice()
+
+	return exitOK
+}
+
+// Parses a byte value given in decimal, hex (0x) or binary (0b) notation.
+func parseByte(str string) (uint8, bool) {
+	val, err := strconv.ParseUint(str, 0, 8)
+	return uint8(val), err == nil
+}
+
+// Collects repeated NAME=VALUE flags.
+type varFlags map[string]string
+
+// Formats the collected variables.
+func (vars varFlags) String() string {
+	return fmt.Sprint(map[string]string(vars))
+}
+
+// Adds a NAME=VALUE pair.
+func (vars varFlags) Set(str string) error {
+	i := strings.Index(str, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected NAME=VALUE, got %q", str)
+	}
+	vars[str[:i]] = str[i+1:]
+	return nil
+}
+
+// Prints a labelled value.
+func printField(label, value string) {
+	fmt.Printf("%-16s %s\n", label+":", value)
+}
+
+// Lists connected devices.
+func listCmd(backend string) int {
+	list, err := listKnown(backend)
+	if err != nil {
+		return failErr(err)
+	}
+	if len(list) == 0 {
+		return fail(exitNotFound, "no matching device found")
+	}
+
+	for _, dev := range list {
+		fmt.Printf("%-10s %-16s %-20s %s\n", dev.Path, dev.Node, dev.Serial, dev.Product)
+	}
+
+	return exitOK
+}
+
+// Shows USB IDs and string descriptors.
+func infoCmd() int {
+	printField("Vendor ID", conf.VendID)
+	printField("Product ID", conf.ProdID)
+	printField("Manufacturer", conf.Manufact)
+	printField("Product", conf.Product)
+	printField("Serial Number", conf.Serial)
+	return exitOK
+}
+
+// Shows device configuration.
+func readCmd(args []string) int {
+	flags := flag.NewFlagSet("read", flag.ContinueOnError)
+	raw := flags.Bool("raw", false, "print READ_ALL response bytes")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if *raw {
+		fmt.Printf("% X\n", micro.Data.MarshalBytes())
+		return exitOK
+	}
+
+	printField("Baud Rate", conf.BaudRate)
+	printField("Actual Baud", usb.BaudFromBytes(micro.Data.Baud_Rate_H, micro.Data.Baud_Rate_L).String())
+	printField("IO Config", conf.IOConfig)
+	printField("Output Default", conf.OutDefault)
+	printField("Tx/Rx LEDs", conf.TxRxLeds)
+	printField("CTS/RTS Pins", conf.CRTS)
+	printField("USBCFG Pin", conf.USBCFG)
+	printField("Suspend Pin", conf.Suspend)
+	printField("UART Polarity", conf.UARTPol)
+	printField("LED Function", conf.LedFunc)
+	printField("Blink Duration", conf.Blink)
+	printField("Port Value", util.FmtBits(micro.Data.IO_Port_Val))
+	return exitOK
+}
+
+// Changes the given fields of device configuration.
+func configureCmd(args []string) int {
+	flags := flag.NewFlagSet("configure", flag.ContinueOnError)
+	baud := flags.String("baud", conf.BaudRate, "baud rate")
+	ioconf := flags.String("io", conf.IOConfig, "IO config bitmap, 1 = input (e.g. 11110000)")
+	outdef := flags.String("default", conf.OutDefault, "output default bitmap")
+	leds := flags.Bool("leds", conf.TxRxLeds == "1", "enable Tx/Rx LEDs")
+	pins := flags.Bool("flow", conf.CRTS == "1", "enable CTS/RTS pins")
+	usbcfg := flags.Bool("usbcfg", conf.USBCFG == "1", "enable USBCFG pin")
+	suspend := flags.Bool("suspend", conf.Suspend == "1", "enable suspend pin")
+	upol := flags.Bool("invert", conf.UARTPol == "1", "invert UART polarity")
+	ledfunc := flags.String("ledfunc", conf.LedFunc, "LED function: blink or toggle")
+	blink := flags.String("blink", conf.Blink, "blink duration in ms: 100 or 200")
+	dry := flags.Bool("dry-run", false, "show the changes and the CONFIGURE report without sending it")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	conf.BaudRate = *baud
+	conf.IOConfig = *ioconf
+	conf.OutDefault = *outdef
+	conf.TxRxLeds = bitStr(*leds)
+	conf.CRTS = bitStr(*pins)
+	conf.USBCFG = bitStr(*usbcfg)
+	conf.Suspend = bitStr(*suspend)
+	conf.UARTPol = bitStr(*upol)
+	conf.LedFunc = *ledfunc
+	conf.Blink = *blink
+
+	if err := validateConf(conf, micro.Data); err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	if err := storeConf(); err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	if *dry {
+		return dryRun()
+	}
+	if err := micro.WriteConfig(); err != nil {
+		return failErr(err)
+	}
+	return exitOK
+}
+
+// Prints the pending changes, preceded by extra ones, and the CONFIGURE
+// report without sending it.
+func dryRun(extra ...usb.Change) int {
+	changes, err := pendingChanges()
+	if err != nil {
+		return failErr(err)
+	}
+	changes = append(extra, changes...)
+
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+	} else {
+		fmt.Println(fmtChanges(changes))
+	}
+
+	fmt.Printf("Report: % X\n", micro.NewReqData())
+	return exitOK
+}
+
+// Programs new USB Vendor/Product IDs.
+func usbidCmd(args []string) int {
+	flags := flag.NewFlagSet("usbid", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "confirm the change")
+	reset := flags.Bool("reset", false, "reset the device to apply the new IDs")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 2 {
+		return fail(exitUsage, "usage: usbid [-yes] [-reset] VID PID")
+	}
+
+	vid, err := parseID(flags.Arg(0))
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	pid, err := parseID(flags.Arg(1))
+	if err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: %s\n", idWarning(vid, pid))
+	if !*yes {
+		return fail(exitUsage, "re-run with -yes to program the new IDs")
+	}
+
+	if err := programIDs(micro, vid, pid); err != nil {
+		return failErr(err)
+	}
+
+	if !*reset {
+		fmt.Println("USB IDs written, replug the device to apply them")
+		return exitOK
+	}
+
+	if err := reopenDevice(); err != nil {
+		return failErr(err)
+	}
+
+	if micro.VendID != vid || micro.ProdID != pid {
+		return fail(exitProtocol, "device came back with USB IDs %s:%s", conf.VendID, conf.ProdID)
+	}
+
+	return exitOK
+}
+
+// Writes string descriptors, resetting the device if asked to.
+func writeStrings(reset bool) int {
+	written, err := storeStrings()
+	if err != nil {
+		return failErr(err)
+	}
+
+	if !written {
+		return exitOK
+	}
+
+	if !reset {
+		fmt.Println("String descriptors written, replug the device to apply them")
+		return exitOK
+	}
+
+	manufact, product := conf.Manufact, conf.Product
+	if err := reopenDevice(); err != nil {
+		return failErr(err)
+	}
+
+	if err := micro.VerifyStrings(manufact, product); err != nil {
+		return failErr(err)
+	}
+
+	return exitOK
+}
+
+// Resets the device if asked to, after string descriptors or USB IDs were
+// written, and checks that it came back with them.
+func resetDescriptors(reset bool, vid, pid usb.ID) int {
+	if !reset {
+		fmt.Println("USB descriptors written, replug the device to apply them")
+		return exitOK
+	}
+
+	manufact, product := conf.Manufact, conf.Product
+	if err := reopenDevice(); err != nil {
+		return failErr(err)
+	}
+
+	if err := micro.VerifyStrings(manufact, product); err != nil {
+		return failErr(err)
+	}
+
+	if micro.VendID != vid || micro.ProdID != pid {
+		return fail(exitProtocol, "device came back with USB IDs %s:%s", conf.VendID, conf.ProdID)
+	}
+
+	return exitOK
+}
+
+// Changes the manufacturer/product string descriptors.
+func stringsCmd(args []string) int {
+	flags := flag.NewFlagSet("strings", flag.ContinueOnError)
+	manufact := flags.String("manufacturer", conf.Manufact, "manufacturer string")
+	product := flags.String("product", conf.Product, "product string")
+	reset := flags.Bool("reset", false, "reset the device to apply the new strings")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	for _, str := range []string{*manufact, *product} {
+		if err := usb.ValidateString(str); err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+	}
+
+	conf.Manufact, conf.Product = *manufact, *product
+	return writeStrings(*reset)
+}
+
+// Parses a pin mask given as a byte value or a list of pins (gp0,gp3).
+func parsePins(str string) (uint8, bool) {
+	if !strings.HasPrefix(strings.ToLower(str), "gp") {
+		return parseByte(str)
+	}
+
+	var mask uint8
+	for _, name := range strings.Split(strings.ToLower(str), ",") {
+		pin, err := strconv.Atoi(strings.TrimPrefix(name, "gp"))
+		if err != nil || pin < 0 || pin >= usb.NumPins {
+			return 0, false
+		}
+		mask |= usb.PinMask(pin)
+	}
+
+	return mask, true
+}
+
+// Shows or drives the GPIO port.
+func gpioCmd(args []string) int {
+	if len(args) == 0 {
+		port, err := micro.ReadPort()
+		if err != nil {
+			return failErr(err)
+		}
+		for pin := 0; pin < usb.NumPins; pin++ {
+			dir := "output"
+			if micro.Data.IO_Bmap&usb.PinMask(pin) != 0 {
+				dir = "input"
+			}
+			printField(fmt.Sprintf("GP%d", pin), fmt.Sprintf("%-6s %d", dir, (port>>uint(pin))&1))
+		}
+		return exitOK
+	}
+
+	if len(args) < 2 {
+		return fail(exitUsage, "usage: gpio [set|clear|toggle PINS | write PINS VAL]")
+	}
+
+	mask, ok := parsePins(args[1])
+	if !ok {
+		return fail(exitUsage, "invalid pins %q", args[1])
+	}
+
+	var err error
+	switch {
+	case len(args) == 2 && args[0] == "set":
+		err = micro.SetPins(mask)
+	case len(args) == 2 && args[0] == "clear":
+		err = micro.ClearPins(mask)
+	case len(args) == 2 && args[0] == "toggle":
+		err = micro.TogglePins(mask)
+	case len(args) == 3 && args[0] == "write":
+		val, ok := parseByte(args[2])
+		if !ok {
+			return fail(exitUsage, "invalid value %q", args[2])
+		}
+		err = micro.WritePins(mask, val)
+	default:
+		return fail(exitUsage, "usage: gpio [set|clear|toggle PINS | write PINS VAL]")
+	}
+
+	if err != nil {
+		return failErr(err)
+	}
+
+	return exitOK
+}
+
+// Prints data in hexdump format.
+func hexDump(addr int, data []byte) {
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		fmt.Printf("%02X: % X\n", addr+i, data[i:end])
+	}
+}
+
+// Reads, writes, dumps or loads user EEPROM.
+func eepromCmd(args []string) int {
+	const eepromUsage = "usage: eeprom read ADDR [LEN] | write ADDR VAL... | dump [FILE] | load FILE"
+
+	if len(args) == 0 {
+		return fail(exitUsage, eepromUsage)
+	}
+
+	switch args[0] {
+	case "dump":
+		image, err := micro.DumpEEPROM()
+		if err != nil {
+			return failErr(err)
+		}
+		if len(args) == 1 {
+			hexDump(0, image)
+			return exitOK
+		}
+		if err := ioutil.WriteFile(args[1], image, 0644); err != nil {
+			return failErr(err)
+		}
+		return exitOK
+	case "load":
+		if len(args) != 2 {
+			return fail(exitUsage, eepromUsage)
+		}
+		image, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return failErr(err)
+		}
+		if len(image) != usb.EEPROMSize {
+			return fail(exitFail, "%s: EEPROM image must be %d bytes", args[1], usb.EEPROMSize)
+		}
+		if err := micro.LoadEEPROM(image); err != nil {
+			return failErr(err)
+		}
+		return exitOK
+	}
+
+	if len(args) < 2 {
+		return fail(exitUsage, eepromUsage)
+	}
+
+	addr, ok := parseByte(args[1])
+	if !ok {
+		return fail(exitUsage, "invalid address %q", args[1])
+	}
+
+	switch {
+	case args[0] == "read" && len(args) <= 3:
+		n := 1
+		if len(args) == 3 {
+			n = util.StrToInt(args[2])
+		}
+		if n <= 0 || int(addr)+n > usb.EEPROMSize {
+			return fail(exitUsage, "invalid length %q", args[2])
+		}
+		data, err := micro.ReadEEPROMRange(int(addr), n)
+		if err != nil {
+			return failErr(err)
+		}
+		hexDump(int(addr), data)
+	case args[0] == "write" && len(args) >= 3:
+		data := make([]byte, len(args)-2)
+		for i, arg := range args[2:] {
+			val, ok := parseByte(arg)
+			if !ok {
+				return fail(exitUsage, "invalid value %q", arg)
+			}
+			data[i] = val
+		}
+		if int(addr)+len(data) > usb.EEPROMSize {
+			return fail(exitUsage, "data exceeds EEPROM size")
+		}
+		if err := micro.WriteEEPROMRange(int(addr), data); err != nil {
+			return failErr(err)
+		}
+	default:
+		return fail(exitUsage, eepromUsage)
+	}
+
+	return exitOK
+}
+
+// Applies a profile to every connected device and reports the outcome
+// per device.
+func provisionCmd(backend string, args []string) int {
+	flags := flag.NewFlagSet("provision", flag.ContinueOnError)
+	vars := make(varFlags)
+	flags.Var(vars, "var", "template variable NAME=VALUE, may be repeated")
+	overrides := flags.String("overrides", "", "file assigning profiles and variables to serial numbers")
+	csvFile := flags.String("csv", "", "write the report as CSV to this file")
+	jsonFile := flags.String("json", "", "write the report as JSON to this file")
+	jobs := flags.Int("jobs", provisionJobs, "number of devices configured at the same time")
+	reset := flags.Bool("reset", false, "reset devices to apply and verify new string descriptors and USB IDs")
+	ids := flags.Bool("ids", false, "program the USB IDs of the profiles, devices with other IDs fail otherwise")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 || *jobs < 1 {
+		return fail(exitUsage, "usage: provision [-overrides FILE] [-var NAME=VALUE]... [-csv FILE] [-json FILE] [-jobs N] [-ids] [-reset] FILE")
+	}
+
+	profile, err := loadProfile(flags.Arg(0))
+	if err != nil {
+		return failErr(err)
+	}
+
+	assigned := make(map[string]assignment)
+	if *overrides != "" {
+		if assigned, err = loadOverrides(*overrides, vars); err != nil {
+			return failErr(err)
+		}
+	}
+
+	// devices without override get the default profile
+	assign := func(info usb.DeviceInfo) assignment {
+		a, ok := assigned[info.Serial]
+		if !ok {
+			a.vars = vars
+		}
+		if a.profile == nil {
+			a.file, a.profile = flags.Arg(0), profile
+		}
+		a.ids = *ids
+		return a
+	}
+
+	list, err := listKnown(backend)
+	if err != nil {
+		return failErr(err)
+	}
+	if len(list) == 0 {
+		return fail(exitNotFound, "no matching device found")
+	}
+
+	results := provisionAll(backend, list, assign, *jobs, *reset)
+
+	failed := 0
+	for _, r := range results {
+		if r.Status != statusOK {
+			failed++
+		}
+		fmt.Printf("%-10s %-20s %-7s %d changes %s\n", r.Path, r.Serial, r.Status, len(r.Changes), r.Error)
+	}
+
+	if *csvFile != "" {
+		if err := writeCSVReport(*csvFile, results); err != nil {
+			return failErr(err)
+		}
+	}
+
+	if *jsonFile != "" {
+		if err := util.ExportJSON(*jsonFile, results); err != nil {
+			return failErr(err)
+		}
+	}
+
+	if failed > 0 {
+		return fail(exitFail, "%d of %d devices failed", failed, len(results))
+	}
+
+	return exitOK
+}
+
+// Runs the auto-provisioning agent.
+func agentCmd(backend string, args []string) int {
+	flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+	interval := flags.Duration("interval", usb.WatchInterval, "interval between two bus scans")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 || *interval <= 0 {
+		return fail(exitUsage, "usage: agent [-interval DUR] RULES")
+	}
+
+	rules, err := loadRules(flags.Arg(0))
+	if err != nil {
+		return failErr(err)
+	}
+
+	runAgent(backend, rules, *interval)
+	return exitOK
+}
+
+// Compares all connected devices with their assigned profiles.
+func auditCmd(backend string, args []string) int {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		return fail(exitUsage, "usage: audit [-json] RULES")
+	}
+
+	rules, err := loadRules(flags.Arg(0))
+	if err != nil {
+		return failErr(err)
+	}
+
+	list, err := listKnown(backend)
+	if err != nil {
+		return failErr(err)
+	}
+
+	results := auditAll(backend, list, rules)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return failErr(err)
+		}
+	} else {
+		printAudit(results)
+	}
+
+	code := exitOK
+	for _, r := range results {
+		if r.Status == statusDrift {
+			return exitDrift
+		}
+		if r.Status == statusFailed {
+			code = exitFail
+		}
+	}
+
+	return code
+}
+
+// Compares every field of a profile with the device without writing
+// anything. Flags may follow the profile name.
+func verifyCmd(backend string, filter usb.Filter, args []string) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.StringVar(&filter.Serial, "serial", filter.Serial, "select device by serial number")
+	flags.StringVar(&filter.Path, "path", filter.Path, "select device by USB port path")
+	vars := make(varFlags)
+	flags.Var(vars, "var", "template variable NAME=VALUE, may be repeated")
+
+	var files []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return exitUsage
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		files, args = append(files, flags.Arg(0)), flags.Args()[1:]
+	}
+
+	if len(files) != 1 {
+		return fail(exitUsage, "usage: verify [-serial X] [-path P] [-var NAME=VALUE]... PROFILE")
+	}
+
+	profile, err := loadProfile(files[0])
+	if err != nil {
+		return failErr(err)
+	}
+
+	if code := openCLI(backend, filter); code != exitOK {
+		return code
+	}
+	defer micro.Close()
+
+	want, err := profileConf(profile, vars, conf)
+	if err != nil {
+		return fail(exitFail, "%s: %v", files[0], err)
+	}
+
+	if !printChecks(checkConf(want, conf, micro.Data)) {
+		return exitMismatch
+	}
+
+	return exitOK
+}
+
+// Saves everything stored on the device to a backup archive.
+func backupCmd(args []string) int {
+	if len(args) != 1 {
+		return fail(exitUsage, "usage: backup FILE")
+	}
+
+	backup, err := readBackup()
+	if err != nil {
+		return failErr(err)
+	}
+
+	if err := saveBackup(args[0], backup); err != nil {
+		return failErr(err)
+	}
+
+	return exitOK
+}
+
+// Writes back the parts of a backup archive that differ from the device.
+func restoreCmd(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := flags.Bool("force", false, "restore a backup taken from another device")
+	reset := flags.Bool("reset", false, "reset the device to apply restored USB IDs and strings")
+	dry := flags.Bool("dry-run", false, "show the changes without writing them")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		return fail(exitUsage, "usage: restore [-force] [-reset] [-dry-run] FILE")
+	}
+
+	backup, err := loadBackup(flags.Arg(0))
+	if err != nil {
+		return failErr(err)
+	}
+
+	current, err := readBackup()
+	if err != nil {
+		return failErr(err)
+	}
+
+	if backup.Device.Serial != current.Device.Serial && !*force {
+		return fail(exitUsage, "backup was taken from device %s, not %s; re-run with -force to restore it anyway",
+			backup.Device.Serial, current.Device.Serial)
+	}
+
+	changes := diffBackup(current, backup)
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return exitOK
+	}
+
+	fmt.Println(fmtChanges(changes))
+	if *dry {
+		return exitOK
+	}
+
+	descriptors, err := restoreBackup(current, backup)
+	if err != nil {
+		return failErr(err)
+	}
+
+	if !descriptors {
+		return exitOK
+	}
+
+	ids, _ := backup.ids()
+	return resetDescriptors(*reset, ids[0], ids[1])
+}
+
+// Saves device configuration to a profile.
+func exportCmd(args []string) int {
+	if len(args) != 1 {
+		return fail(exitUsage, "usage: export FILE")
+	}
+
+	for _, warning := range profileWarnings(micro.Data) {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning)
+	}
+
+	if err := saveProfile(args[0], newProfile(conf)); err != nil {
+		return failErr(err)
+	}
+
+	return exitOK
+}
+
+// Applies a profile to the device.
+func importCmd(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	vars := make(varFlags)
+	flags.Var(vars, "var", "template variable NAME=VALUE, may be repeated")
+	reset := flags.Bool("reset", false, "reset the device to apply new string descriptors and USB IDs")
+	dry := flags.Bool("dry-run", false, "show the changes and the CONFIGURE report without sending it")
+	ids := flags.Bool("ids", false, "program the USB IDs of the profile if they differ from the device")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() != 1 {
+		return fail(exitUsage, "usage: import [-var NAME=VALUE]... [-ids] [-reset] [-dry-run] FILE")
+	}
+
+	profile, err := loadProfile(flags.Arg(0))
+	if err != nil {
+		return failErr(err)
+	}
+
+	if err := applyProfile(profile, vars); err != nil {
+		return fail(exitFail, "%s: %v", flags.Arg(0), err)
+	}
+
+	vid, pid, err := confIDs(conf)
+	if err != nil {
+		return fail(exitFail, "%s: %v", flags.Arg(0), err)
+	}
+	newIDs := vid != micro.VendID || pid != micro.ProdID
+
+	var idChanges []usb.Change
+	if newIDs {
+		change := idChange(micro, vid, pid)
+		if !*ids {
+			return fail(exitFail, "%s: profile changes the USB IDs from %s to %s, re-run with -ids to program them",
+				flags.Arg(0), change.Old, change.New)
+		}
+		idChanges = append(idChanges, change)
+	}
+
+	if err := storeConf(); err != nil {
+		return fail(exitUsage, "%v", err)
+	}
+	if *dry {
+		return dryRun(idChanges...)
+	}
+	if err := micro.WriteConfig(); err != nil {
+		return failErr(err)
+	}
+
+	if newIDs {
+		if err := programIDs(micro, vid, pid); err != nil {
+			return failErr(err)
+		}
+	}
+
+	written, err := storeStrings()
+	if err != nil {
+		return failErr(err)
+	}
+
+	if !written && !newIDs {
+		return exitOK
+	}
+
+	return resetDescriptors(*reset, vid, pid)
+}
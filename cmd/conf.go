@@ -0,0 +1,337 @@
+// Device configuration shared by GUI and command line interface.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Represents device configuration for logging.
+type Conf struct {
+	VendID     string
+	ProdID     string
+	BaudRate   string
+	IOConfig   string
+	OutDefault string
+	TxRxLeds   string
+	CRTS       string
+	USBCFG     string
+	Suspend    string
+	UARTPol    string
+	LedFunc    string
+	Blink      string
+	Manufact   string
+	Product    string
+	Serial     string
+}
+
+// Opens the MCP2200 matching the filter through given backend
+// and reads its configuration. The device is searched with the
+// factory default USB IDs and all IDs programmed earlier.
+func openDevice(backend string, filter usb.Filter) error {
+	var openErr error
+
+	for _, id := range knownIDs() {
+		// create new usb.MCP object
+		dev := new(usb.MCP)
+
+		// set Vendor/Product IDs for MCP2200 device
+		dev.VendID, dev.ProdID = id[0], id[1]
+
+		// open USB device through the selected backend
+		transport, err := usb.Open(backend, dev.VendID, dev.ProdID, filter)
+		if err != nil {
+			if openErr == nil {
+				openErr = err
+			}
+			continue
+		}
+		dev.Transport = transport
+
+		if err := loadConf(dev); err != nil {
+			dev.Close()
+			return err
+		}
+
+		micro = dev
+		devBackend, devFilter = backend, filter
+		return nil
+	}
+
+	return openErr
+}
+
+// define how long to wait for a reset device to re-enumerate
+const (
+	reopenInterval = 500 * time.Millisecond
+	reopenTries    = 10
+)
+
+// Resets a device and opens it again once it re-enumerated, since the
+// reset invalidates the old handle. The device is searched with the
+// filter under all known USB IDs, which include newly programmed ones.
+// The old handle is closed in any case.
+func reopenAfterReset(backend string, dev *usb.MCP, filter usb.Filter) (*usb.MCP, error) {
+	err := dev.Reload()
+	dev.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for try := 0; try < reopenTries; try++ {
+		time.Sleep(reopenInterval)
+
+		for _, id := range knownIDs() {
+			var transport usb.Transport
+			if transport, err = usb.Open(backend, id[0], id[1], filter); err == nil {
+				return &usb.MCP{Transport: transport, VendID: id[0], ProdID: id[1]}, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// Resets the opened device, opens it again and re-reads its
+// configuration and string descriptors from the new handle. On failure
+// micro is nil or the new handle, left for the caller to close.
+func reopenDevice() error {
+	dev, err := reopenAfterReset(devBackend, micro, deviceFilter())
+	micro = dev
+	if err != nil {
+		return err
+	}
+
+	return loadConf(micro)
+}
+
+// Reads device configuration and string descriptors into Conf.
+// Conf is left untouched if the device can not be read.
+func loadConf(dev *usb.MCP) error {
+	c, err := readConf(dev)
+	if err != nil {
+		return err
+	}
+
+	// parse Alt_Opts and Alt_Pins data
+	opts, gpio = new(usb.AltOpts), new(usb.AltPins)
+	opts.UnmarshalByte(dev.Data.Alt_Opts)
+	gpio.UnmarshalByte(dev.Data.Alt_Pins)
+
+	conf = c
+	return nil
+}
+
+// Reads device configuration into dev.Data and returns it together with
+// the string descriptors as Conf.
+func readConf(dev *usb.MCP) (*Conf, error) {
+	c := new(Conf)
+	vid, pid := uint16(dev.VendID), uint16(dev.ProdID)
+	c.VendID, c.ProdID = util.UintToStr(vid, pid)
+
+	// send READ_ALL command request to MCP2200
+	if _, err := dev.ReadAllCmd(); err != nil {
+		return nil, err
+	}
+
+	// parse READ_ALL command response from MCP2200
+	data, err := dev.ParseResponse()
+	if err != nil {
+		return nil, err
+	}
+
+	// read string descriptors
+	if c.Manufact, err = dev.ReadManufacturer(); err != nil {
+		return nil, err
+	}
+	if c.Product, err = dev.ReadProduct(); err != nil {
+		return nil, err
+	}
+	if c.Serial, err = dev.ReadSerial(); err != nil {
+		return nil, err
+	}
+
+	dev.Data = data
+
+	// parse Alt_Opts and Alt_Pins data
+	opts, pins := new(usb.AltOpts), new(usb.AltPins)
+	opts.UnmarshalByte(data.Alt_Opts)
+	pins.UnmarshalByte(data.Alt_Pins)
+
+	// set Conf
+	baud := usb.BaudFromBytes(data.Baud_Rate_H, data.Baud_Rate_L)
+	c.BaudRate = util.IntToStr(baud.Rate)
+	c.IOConfig = util.FmtBits(data.IO_Bmap)
+	c.OutDefault = util.FmtBits(data.IO_Default)
+	c.TxRxLeds = bitStr(pins.TxLED)
+	c.CRTS = bitStr(opts.HW_Flow)
+	c.USBCFG = bitStr(pins.USBCFG)
+	c.Suspend = bitStr(pins.SSPND)
+	c.UARTPol = bitStr(opts.Invert)
+
+	// set LED configuration options
+	c.LedFunc, c.Blink = configLED(opts)
+
+	return c, nil
+}
+
+// Encodes Conf into the CONFIGURE request data.
+func storeConf() error {
+	if err := encodeConf(conf, micro.Data); err != nil {
+		return err
+	}
+
+	opts.UnmarshalByte(micro.Data.Alt_Opts)
+	gpio.UnmarshalByte(micro.Data.Alt_Pins)
+	return nil
+}
+
+// Encodes a configuration into CONFIGURE request data.
+// Reserved bits are kept as read from the device.
+func encodeConf(c *Conf, data *usb.Data) error {
+	baud, err := confBaud(c, data)
+	if err != nil {
+		return err
+	}
+
+	data.Baud_Rate_H, data.Baud_Rate_L = baud.Bytes()
+	data.IO_Bmap = util.BitsToUint8(c.IOConfig)
+	data.IO_Default = util.BitsToUint8(c.OutDefault)
+
+	opts, pins := new(usb.AltOpts), new(usb.AltPins)
+	opts.UnmarshalByte(data.Alt_Opts)
+	pins.UnmarshalByte(data.Alt_Pins)
+
+	pins.TxLED, pins.RxLED = c.TxRxLeds == "1", c.TxRxLeds == "1"
+	pins.USBCFG = c.USBCFG == "1"
+	pins.SSPND = c.Suspend == "1"
+	opts.HW_Flow = c.CRTS == "1"
+	opts.Invert = c.UARTPol == "1"
+
+	if c.LedFunc == "blink" {
+		opts.RxTGL = false
+		opts.TxTGL = false
+	} else if c.LedFunc == "toggle" {
+		opts.RxTGL = true
+		opts.TxTGL = true
+	}
+
+	// LEDX is kept in toggle mode too, so the blink duration reads back
+	opts.LEDX = c.Blink == "200"
+
+	data.Alt_Pins = pins.MarshalByte()
+	data.Alt_Opts = opts.MarshalByte()
+
+	return nil
+}
+
+// Compares the CONFIGURE request data and the strings held in Conf with
+// the current device state. Nothing is written to the device.
+func pendingChanges() ([]usb.Change, error) {
+	return confChanges(micro, conf)
+}
+
+// Compares the CONFIGURE request data in dev.Data and the strings of a
+// configuration with the current device state.
+func confChanges(dev *usb.MCP, c *Conf) ([]usb.Change, error) {
+	current, err := dev.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := usb.DiffConfig(current, dev.Data)
+
+	manufact, err := dev.ReadManufacturer()
+	if err != nil {
+		return nil, err
+	}
+	if c.Manufact != manufact {
+		changes = append(changes, usb.Change{Field: "Manufacturer", Old: manufact, New: c.Manufact})
+	}
+
+	product, err := dev.ReadProduct()
+	if err != nil {
+		return nil, err
+	}
+	if c.Product != product {
+		changes = append(changes, usb.Change{Field: "Product", Old: product, New: c.Product})
+	}
+
+	return changes, nil
+}
+
+// Formats changes one per line.
+func fmtChanges(changes []usb.Change) string {
+	var lines []string
+	for _, change := range changes {
+		lines = append(lines, fmt.Sprintf("%-18s %s -> %s", change.Field, change.Old, change.New))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Writes the string descriptors held in Conf that differ from the ones
+// reported by the device and reports whether anything was written.
+// The new strings take effect after the device re-enumerates.
+func storeStrings() (bool, error) {
+	return writeConfStrings(micro, conf)
+}
+
+// Writes the string descriptors of a configuration that differ from the
+// ones reported by the device and reports whether anything was written.
+func writeConfStrings(dev *usb.MCP, c *Conf) (bool, error) {
+	manufact, err := dev.ReadManufacturer()
+	if err != nil {
+		return false, err
+	}
+
+	product, err := dev.ReadProduct()
+	if err != nil {
+		return false, err
+	}
+
+	written := false
+	if c.Manufact != manufact {
+		if err := dev.WriteManufacturer(c.Manufact); err != nil {
+			return written, err
+		}
+		written = true
+	}
+
+	if c.Product != product {
+		if err := dev.WriteProduct(c.Product); err != nil {
+			return written, err
+		}
+		written = true
+	}
+
+	return written, nil
+}
+
+// Returns "1" for set flags, "0" otherwise.
+func bitStr(flag bool) string {
+	if flag {
+		return "1"
+	}
+	return "0"
+}
+
+// Returns the LED function and blink duration set in Alt_Opts.
+func configLED(opts *usb.AltOpts) (ledfunc, duration string) {
+	if opts.RxTGL || opts.TxTGL {
+		ledfunc = "toggle"
+	} else {
+		ledfunc = "blink"
+	}
+
+	if opts.LEDX {
+		duration = "200"
+	} else {
+		duration = "100"
+	}
+	return
+}
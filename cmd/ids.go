@@ -0,0 +1,156 @@
+// USB Vendor/Product IDs the MCP2200 may enumerate with.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Factory default USB IDs of the MCP2200.
+const (
+	defaultVendID usb.ID = 0x04D8
+	defaultProdID usb.ID = 0x00DF
+)
+
+// Represents a Vendor/Product ID pair programmed into a device.
+type USBID struct {
+	VendID string `json:"vendor_id"`
+	ProdID string `json:"product_id"`
+}
+
+// Parses a USB ID given in hex (0x04D8) or decimal notation.
+func parseID(str string) (usb.ID, error) {
+	id, err := strconv.ParseUint(str, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid USB ID %q", str)
+	}
+	return usb.ID(id), nil
+}
+
+// Parses the USB IDs held in a configuration.
+func confIDs(c *Conf) (usb.ID, usb.ID, error) {
+	vid, err := parseID(c.VendID)
+	if err != nil {
+		return 0, 0, err
+	}
+	pid, err := parseID(c.ProdID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return vid, pid, nil
+}
+
+// Describes the change from the USB IDs a device is opened with to new ones.
+func idChange(dev *usb.MCP, vid, pid usb.ID) usb.Change {
+	oldVID, oldPID := util.UintToStr(uint16(dev.VendID), uint16(dev.ProdID))
+	newVID, newPID := util.UintToStr(uint16(vid), uint16(pid))
+	return usb.Change{Field: "USB ID", Old: oldVID + ":" + oldPID, New: newVID + ":" + newPID}
+}
+
+// Returns the file keeping the USB IDs programmed by microconfig.
+func idsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "microconfig", "ids.json")
+}
+
+// Returns the USB IDs to search devices with: the factory defaults
+// followed by all IDs programmed earlier. Invalid entries are skipped.
+func knownIDs() [][2]usb.ID {
+	ids := [][2]usb.ID{{defaultVendID, defaultProdID}}
+
+	var saved []USBID
+	if err := util.ImportJSON(idsFile(), &saved); err != nil {
+		return ids
+	}
+
+	for _, entry := range saved {
+		vid, err := parseID(entry.VendID)
+		if err != nil {
+			continue
+		}
+		pid, err := parseID(entry.ProdID)
+		if err != nil {
+			continue
+		}
+		if vid != defaultVendID || pid != defaultProdID {
+			ids = append(ids, [2]usb.ID{vid, pid})
+		}
+	}
+
+	return ids
+}
+
+// Serializes updates of the remembered IDs by provisioning workers.
+var idsMu sync.Mutex
+
+// Adds a Vendor/Product ID pair to the remembered IDs.
+func rememberIDs(vid, pid usb.ID) error {
+	idsMu.Lock()
+	defer idsMu.Unlock()
+
+	var saved []USBID
+	for _, id := range knownIDs()[1:] {
+		if id == [2]usb.ID{vid, pid} {
+			return nil
+		}
+		entry := USBID{}
+		entry.VendID, entry.ProdID = util.UintToStr(uint16(id[0]), uint16(id[1]))
+		saved = append(saved, entry)
+	}
+
+	entry := USBID{}
+	entry.VendID, entry.ProdID = util.UintToStr(uint16(vid), uint16(pid))
+	saved = append(saved, entry)
+
+	file := idsFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	return util.ExportJSON(file, saved)
+}
+
+// Lists devices enumerated with any of the known USB IDs.
+func listKnown(backend string) ([]usb.DeviceInfo, error) {
+	var list []usb.DeviceInfo
+	for _, id := range knownIDs() {
+		found, err := usb.List(backend, id[0], id[1])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, found...)
+	}
+	return list, nil
+}
+
+// Returns the warning shown before new USB IDs are programmed.
+func idWarning(vid, pid usb.ID) string {
+	oldVID, oldPID := util.UintToStr(uint16(micro.VendID), uint16(micro.ProdID))
+	newVID, newPID := util.UintToStr(uint16(vid), uint16(pid))
+
+	return fmt.Sprintf("The device will enumerate as %s:%s instead of %s:%s after the next reset.\n"+
+		"Drivers and udev rules matching %s:%s will no longer apply to it, and the\n"+
+		"operating system may not recognize it as a serial port anymore.\n"+
+		"The new IDs are remembered in %s.",
+		newVID, newPID, oldVID, oldPID, oldVID, oldPID, idsFile())
+}
+
+// Writes new USB IDs to the device. The IDs are remembered first so the
+// device can be found again after it re-enumerates.
+func programIDs(dev *usb.MCP, vid, pid usb.ID) error {
+	if err := rememberIDs(vid, pid); err != nil {
+		return fmt.Errorf("could not remember USB IDs: %v", err)
+	}
+
+	return dev.WriteVIDPID(vid, pid)
+}
@@ -0,0 +1,130 @@
+// Log sinks of the command line interface, agent and GUI.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/korayeyinc/microconfig/gui"
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// define log file rotation limits
+const (
+	logFileSize    = 1 << 20
+	logFileBackups = 3
+)
+
+// Represents the verbosity of each log sink. Empty levels fall back to
+// the defaults of the command, "off" disables a sink.
+type logConfig struct {
+	Console   string
+	Stderr    string
+	File      string
+	FileLevel string
+	Journal   string
+}
+
+// Returns the log configuration set in the environment.
+func envLogConfig() logConfig {
+	return logConfig{
+		Console:   os.Getenv("MICROCONFIG_CONSOLE_LEVEL"),
+		Stderr:    os.Getenv("MICROCONFIG_LOG_LEVEL"),
+		File:      os.Getenv("MICROCONFIG_LOG_FILE"),
+		FileLevel: os.Getenv("MICROCONFIG_LOG_FILE_LEVEL"),
+		Journal:   os.Getenv("MICROCONFIG_JOURNAL_LEVEL"),
+	}
+}
+
+// Parses a sink level, an empty string selects the default.
+func sinkLevel(str string, def util.Level) (util.Level, error) {
+	if str == "" {
+		return def, nil
+	}
+	return util.ParseLevel(str)
+}
+
+// Sets up the logger with the configured sinks. Stderr defaults to
+// stderrLevel, the log file to debug and the journal is off by default.
+// Device reports are logged if any sink takes debug records.
+func setupLog(cfg logConfig, stderrLevel util.Level) error {
+	logger = util.NewLogger()
+
+	level, err := sinkLevel(cfg.Stderr, stderrLevel)
+	if err != nil {
+		return err
+	}
+	logger.AddSink(util.StreamSink{W: os.Stderr}, level)
+
+	if cfg.File != "" {
+		if level, err = sinkLevel(cfg.FileLevel, util.LevelDebug); err != nil {
+			return err
+		}
+		file, err := util.NewFileSink(cfg.File, logFileSize, logFileBackups)
+		if err != nil {
+			return err
+		}
+		logger.AddSink(file, level)
+	}
+
+	if level, err = sinkLevel(cfg.Journal, util.LevelOff); err != nil {
+		return err
+	}
+	if level != util.LevelOff {
+		journal, err := util.NewJournalSink()
+		if err != nil {
+			return err
+		}
+		logger.AddSink(journal, level)
+	}
+
+	usb.Log = logger
+	return nil
+}
+
+// Adds the GTK info console as log sink, taking info records and above
+// by default.
+func addConsoleSink(cfg logConfig) error {
+	level, err := sinkLevel(cfg.Console, util.LevelInfo)
+	if err != nil {
+		return err
+	}
+
+	logger.AddSink(util.SinkFunc(func(r *util.Record) error {
+		gui.AppendText(buffer, fmt.Sprintf(" %s [%s]\t%s%s\n", r.Time.Format("15:04:05"), r.Level, r.Message, r.FieldText()))
+		return nil
+	}), level)
+	return nil
+}
+
+// Returns the device configuration as log fields named as in profiles.
+func (c *Conf) fields() []util.Field {
+	return []util.Field{
+		util.F("vendor_id", c.VendID),
+		util.F("product_id", c.ProdID),
+		util.F("baud_rate", c.BaudRate),
+		util.F("io_config", c.IOConfig),
+		util.F("output_default", c.OutDefault),
+		util.F("txrx_leds", c.TxRxLeds),
+		util.F("usbcfg", c.USBCFG),
+		util.F("suspend", c.Suspend),
+		util.F("hw_flow", c.CRTS),
+		util.F("invert", c.UARTPol),
+		util.F("led_function", c.LedFunc),
+		util.F("blink_duration", c.Blink),
+	}
+}
+
+// Returns the logger for messages about the opened device.
+func devLog() *util.Logger {
+	return logger.With(util.F("serial", conf.Serial))
+}
+
+// Logs the opened device with its descriptors and configuration.
+func logDevice() {
+	log := devLog()
+	log.Info("USB device connected", util.F("manufacturer", conf.Manufact), util.F("product", conf.Product))
+	log.Info("Device configuration read", conf.fields()...)
+}
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/korayeyinc/microconfig/gui"
 	"github.com/korayeyinc/microconfig/usb"
 	"github.com/korayeyinc/microconfig/util"
@@ -22,32 +27,49 @@ var (
 	radio   *Radio
 	spin    *Spin
 	toggle  *Toggle
+	pins    *Pins
+	logger  *util.Logger
+
+	// backend and filter of the opened device
+	devBackend string
+	devFilter  usb.Filter
+	selecting  bool
+
+	// connection state of the opened device
+	online       bool
+	reconnecting bool
+	watchStop    chan struct{}
+
+	// called once the device was reopened after a reset
+	afterReconnect func()
+
+	// last GPIO poll error shown in the console and number of
+	// consecutive failed polls
+	pollErr   string
+	pollFails int
 )
 
-// Represents device configuration for logging.
-type Conf struct {
-	VendID     string
-	ProdID     string
-	BaudRate   string
-	IOConfig   string
-	OutDefault string
-	TxRxLeds   string
-	CRTS       string
-	USBCFG     string
-	Suspend    string
-	UARTPol    string
-	LedFunc    string
-	Blink      string
-	Manufact   string
-	Product    string
-	Serial     string
-}
+// GPIO panel polling interval in milliseconds.
+const pollInterval = 500
+
+// Delays between reconnect attempts in milliseconds. The delay is doubled
+// after every failed attempt up to reconnectMaxInterval.
+const (
+	reconnectInterval    = 500
+	reconnectMaxInterval = 8000
+)
+
+// Number of consecutive failed GPIO polls after which the device is
+// closed and reopened.
+const pollRetries = 3
 
 type Button struct {
 	Config  gui.Button
 	Reset   gui.Button
 	Export  gui.Button
 	Import  gui.Button
+	Backup  gui.Button
+	Restore gui.Button
 	Reload  gui.Button
 	Quit    gui.Button
 	Console gui.Button
@@ -60,6 +82,8 @@ type Icon struct {
 
 type Combo struct {
 	BaudRate gui.Combo
+	BaudInfo gui.TextLabel
+	Device   gui.Combo
 }
 
 type Input struct {
@@ -93,96 +117,431 @@ type Console struct {
 	View gui.TextView
 }
 
+type Pins struct {
+	Dir      [8]gui.TextLabel
+	Level    [8]gui.Icon
+	Out      [8]gui.Toggle
+	updating bool
+}
+
 type Panel struct {
 	Header gui.Header
 	Conf   gui.Grid
+	GPIO   gui.Grid
 	Info   gui.Grid
 }
 
-// Stores device configuration to NVRAM.
-func configDevice() {
+// Reads device configuration from the config panel widgets.
+func readWidgets() {
 	conf.BaudRate = combo.BaudRate.GetActiveText()
-	micro.Data.Baud_Rate_H, micro.Data.Baud_Rate_L = micro.CalcHLBytes(conf.BaudRate)
-
 	conf.IOConfig, _ = input.IOConf.GetText()
-	micro.Data.IO_Bmap = util.StrToUint8(conf.IOConfig)
-
 	conf.OutDefault, _ = input.OutDef.GetText()
-	micro.Data.IO_Default = util.StrToUint8(conf.OutDefault)
+	conf.TxRxLeds = bitStr(toggle.Leds.GetActive())
+	conf.CRTS = bitStr(toggle.Pins.GetActive())
+	conf.USBCFG = bitStr(toggle.Usbcfg.GetActive())
+	conf.Suspend = bitStr(toggle.Suspend.GetActive())
+	conf.UARTPol = bitStr(toggle.UPol.GetActive())
 
-	if toggle.Leds.GetActive() {
-		conf.TxRxLeds = "1"
-		gpio.TxLED = "1"
-		gpio.RxLED = "1"
-	} else {
-		conf.TxRxLeds = "0"
-		gpio.TxLED = "0"
-		gpio.RxLED = "0"
+	if radio.BlinkLeds.GetActive() {
+		conf.LedFunc = "blink"
+	} else if radio.ToggleLeds.GetActive() {
+		conf.LedFunc = "toggle"
 	}
+	conf.Blink = util.IntToStr(int(spin.Duration.GetValue()))
 
-	if toggle.Pins.GetActive() {
-		conf.CRTS = "1"
-		opts.HW_Flow = "1"
-	} else {
-		conf.CRTS = "0"
-		opts.HW_Flow = "0"
+	conf.Manufact, _ = input.Manufacturer.GetText()
+	conf.Product, _ = input.Product.GetText()
+}
+
+// Sets the config panel widgets from device configuration.
+func setWidgets() {
+	// set IDs
+	input.VendID.SetText(conf.VendID)
+	input.ProdID.SetText(conf.ProdID)
+
+	// set active vals
+	gui.SetComboText(combo.BaudRate, conf.BaudRate)
+	input.IOConf.SetText(conf.IOConfig)
+	input.OutDef.SetText(conf.OutDefault)
+
+	toggle.Leds.SetActive(conf.TxRxLeds == "1")
+	toggle.Pins.SetActive(conf.CRTS == "1")
+	toggle.Usbcfg.SetActive(conf.USBCFG == "1")
+	toggle.Suspend.SetActive(conf.Suspend == "1")
+	toggle.UPol.SetActive(conf.UARTPol == "1")
+
+	// set active radio buttons
+	if conf.LedFunc == "blink" {
+		radio.BlinkLeds.SetActive(true)
+	} else if conf.LedFunc == "toggle" {
+		radio.ToggleLeds.SetActive(true)
 	}
 
-	if toggle.Usbcfg.GetActive() {
-		conf.USBCFG = "1"
-		gpio.USBCFG = "1"
+	if conf.Blink == "200" {
+		spin.Duration.SetValue(200.0)
 	} else {
-		conf.USBCFG = "0"
-		gpio.USBCFG = "0"
+		spin.Duration.SetValue(100.0)
 	}
+}
 
-	if toggle.Suspend.GetActive() {
-		conf.Suspend = "1"
-		gpio.SSPND = "1"
-	} else {
-		conf.Suspend = "0"
-		gpio.SSPND = "0"
+// Stores device configuration to NVRAM.
+func configDevice() {
+	if !online {
+		return
 	}
 
-	if toggle.UPol.GetActive() {
-		conf.UARTPol = "1"
-		opts.Invert = "1"
-	} else {
-		conf.UARTPol = "0"
-		opts.Invert = "0"
+	readWidgets()
+	if err := validateConf(conf, micro.Data); err != nil {
+		gui.ErrorDialog(win, "Invalid configuration", err.Error())
+		return
 	}
 
-	if radio.BlinkLeds.GetActive() {
-		opts.RxTGL = "0"
-		opts.TxTGL = "0"
-		if spin.Duration.GetValue() == 100.0 {
-			opts.LEDX = "0"
-		} else {
-			opts.LEDX = "1"
+	// keep device state to restore it if the changes are not written
+	saved := *micro.Data
+	restore := func() {
+		*micro.Data = saved
+		opts.UnmarshalByte(saved.Alt_Opts)
+		gpio.UnmarshalByte(saved.Alt_Pins)
+	}
+
+	if err := storeConf(); err != nil {
+		gui.ErrorDialog(win, "Invalid configuration", err.Error())
+		return
+	}
+
+	changes, err := pendingChanges()
+	if err != nil {
+		restore()
+		deviceError(err)
+		return
+	}
+
+	written := false
+	if len(changes) > 0 {
+		if !gui.ReviewDialog(win, "Write configuration?", fmtChanges(changes)) {
+			restore()
+			return
 		}
-	} else if radio.ToggleLeds.GetActive() {
-		opts.RxTGL = "1"
-		opts.TxTGL = "1"
+
+		if err := micro.WriteConfig(); err != nil {
+			deviceError(err)
+			return
+		}
+
+		devLog().Info("Device configuration written and verified")
+		setPins()
+
+		if written, err = storeStrings(); err != nil {
+			deviceError(err)
+			return
+		}
+		if written {
+			devLog().Info("String descriptors written", util.F("manufacturer", conf.Manufact), util.F("product", conf.Product))
+		}
+	}
+
+	// new descriptors take effect after the device re-enumerates
+	programmed := writeIDs()
+	if written || programmed {
+		manufact, product := conf.Manufact, conf.Product
+		afterReconnect = func() { verifyStrings(written, manufact, product) }
+		resetDevice()
+	} else if len(changes) == 0 {
+		devLog().Info("No changes to write")
+	}
+}
+
+// Checks the string descriptors reported by the reopened device after
+// a reset, if strings were written.
+func verifyStrings(written bool, manufact, product string) {
+	if !written || !online {
+		return
+	}
+
+	if err := micro.VerifyStrings(manufact, product); err != nil {
+		deviceError(err)
+		return
+	}
+
+	devLog().Info("String descriptors verified")
+}
+
+// Re-reads the device after a reset if it is still connected.
+func reloadDevice() {
+	if !online {
+		return
+	}
+
+	if err := loadConf(micro); err != nil {
+		deviceError(err)
+		return
 	}
 
-	gpioStr := util.FmtPinStr(gpio.SSPND, gpio.USBCFG, gpio.RxLED, gpio.TxLED)
-	micro.Data.Alt_Pins = util.StrToUint8(gpioStr)
+	showDevice()
+}
+
+// Programs the Vendor/Product IDs entered in the config panel once
+// the user confirmed the change and reports whether they were written.
+func writeIDs() bool {
+	vidStr, _ := input.VendID.GetText()
+	pidStr, _ := input.ProdID.GetText()
+
+	vid, err := parseID(vidStr)
+	if err != nil {
+		gui.ErrorDialog(win, "Invalid USB ID", err.Error())
+		return false
+	}
+
+	pid, err := parseID(pidStr)
+	if err != nil {
+		gui.ErrorDialog(win, "Invalid USB ID", err.Error())
+		return false
+	}
+
+	if vid == micro.VendID && pid == micro.ProdID {
+		return false
+	}
+
+	if !gui.ConfirmDialog(win, "Change USB Vendor/Product ID?", idWarning(vid, pid)) {
+		input.VendID.SetText(conf.VendID)
+		input.ProdID.SetText(conf.ProdID)
+		return false
+	}
+
+	if err := programIDs(micro, vid, pid); err != nil {
+		deviceError(err)
+		return false
+	}
+
+	newVID, newPID := util.UintToStr(uint16(vid), uint16(pid))
+	devLog().Warn("USB IDs changed", util.F("vendor_id", newVID), util.F("product_id", newPID))
+
+	watchDevices(vid, pid)
+	return true
+}
+
+// Shows the actual rate and deviation of the entered baud rate.
+func showBaud() {
+	baud, err := confBaud(&Conf{BaudRate: combo.BaudRate.GetActiveText()}, micro.Data)
+	if err != nil {
+		combo.BaudInfo.SetText(err.Error())
+		return
+	}
+	combo.BaudInfo.SetText(baud.String())
+}
+
+// Sets GPIO panel directions from device configuration.
+// Pins taken over by alternate functions are greyed out.
+func setPins() {
+	alt := gpio.Mask()
+
+	for pin := 0; pin < usb.NumPins; pin++ {
+		mask := usb.PinMask(pin)
+		output := micro.Data.IO_Bmap&mask == 0
+
+		switch {
+		case alt&mask != 0:
+			pins.Dir[pin].SetText(usb.AltPinName(pin))
+		case output:
+			pins.Dir[pin].SetText("Output")
+		default:
+			pins.Dir[pin].SetText("Input")
+		}
+
+		pins.Dir[pin].SetSensitive(alt&mask == 0)
+		pins.Level[pin].SetSensitive(alt&mask == 0)
+		pins.Out[pin].SetSensitive(output && alt&mask == 0)
+	}
+
+	pollPins()
+}
 
-	optsStr := util.FmtOptStr(opts.RxTGL, opts.TxTGL, opts.LEDX, opts.Invert, opts.HW_Flow)
-	micro.Data.Alt_Opts = util.StrToUint8(optsStr)
+// Reads live pin levels and updates the GPIO panel.
+func pollPins() bool {
+	if !online {
+		return true
+	}
+
+	port, err := micro.ReadPort()
+	if err != nil {
+		pollError(err)
+		return true
+	}
+	pollErr, pollFails = "", 0
+
+	pins.updating = true
+	for pin := 0; pin < usb.NumPins; pin++ {
+		high := port&usb.PinMask(pin) != 0
+		gui.SetLevel(pins.Level[pin], high)
+		pins.Out[pin].SetActive(high)
+	}
+	pins.updating = false
 
-	micro.ConfigCmd()
+	return true
 }
 
-// Exports device configuration to XML.
-func exportXML() {
-	file := gui.ChooseXML(win)
-	util.ExportXML(file, conf)
+// Drives an output pin from its GPIO panel switch.
+func drivePin(pin int) {
+	if pins.updating {
+		return
+	}
+
+	if err := micro.SetPin(pin, pins.Out[pin].GetActive()); err != nil {
+		deviceError(err)
+		return
+	}
+	pollPins()
+}
+
+// Exports live device configuration to a profile.
+func exportProfile() {
+	file, err := gui.SaveProfile(win)
+	if err != nil {
+		gui.ErrorDialog(win, "Export failed", err.Error())
+		return
+	}
+	if file == "" {
+		return
+	}
+
+	if err := loadConf(micro); err != nil {
+		deviceError(err)
+		return
+	}
+
+	if warnings := profileWarnings(micro.Data); len(warnings) > 0 {
+		if !gui.ConfirmDialog(win, "Export anyway?", strings.Join(warnings, "\n")) {
+			return
+		}
+	}
+
+	if err := saveProfile(file, newProfile(conf)); err != nil {
+		gui.ErrorDialog(win, "Export failed", err.Error())
+	}
+}
+
+// Imports device configuration from a profile and fills the widgets.
+func importProfile() {
+	file, err := gui.ChooseProfile(win)
+	if err != nil {
+		gui.ErrorDialog(win, "Import failed", err.Error())
+		return
+	}
+	if file == "" {
+		return
+	}
+
+	profile, err := loadProfile(file)
+	if err != nil {
+		gui.ErrorDialog(win, "Import failed", err.Error())
+		return
+	}
+
+	if err := applyProfile(profile, nil); err != nil {
+		gui.ErrorDialog(win, "Import failed", err.Error())
+		return
+	}
+
+	setWidgets()
+	input.Manufacturer.SetText(conf.Manufact)
+	input.Product.SetText(conf.Product)
+}
+
+// Saves everything stored on the device to a backup archive.
+func backupDevice() {
+	if !online {
+		return
+	}
+
+	file, err := gui.SaveBackup(win, "microconfig-"+conf.Serial+".json")
+	if err != nil {
+		gui.ErrorDialog(win, "Backup failed", err.Error())
+		return
+	}
+	if file == "" {
+		return
+	}
+
+	backup, err := readBackup()
+	if err != nil {
+		deviceError(err)
+		return
+	}
+
+	if err := saveBackup(file, backup); err != nil {
+		gui.ErrorDialog(win, "Backup failed", err.Error())
+		return
+	}
+
+	devLog().Info("Device backed up", util.F("file", file))
 }
 
-// Imports device configuration from XML.
-func importXML() {
-	gui.ChooseXML(win)
+// Writes back the parts of a backup archive that differ from the device
+// once the user reviewed them.
+func restoreDevice() {
+	if !online {
+		return
+	}
+
+	file, err := gui.ChooseBackup(win)
+	if err != nil {
+		gui.ErrorDialog(win, "Restore failed", err.Error())
+		return
+	}
+	if file == "" {
+		return
+	}
+
+	backup, err := loadBackup(file)
+	if err != nil {
+		gui.ErrorDialog(win, "Restore failed", err.Error())
+		return
+	}
+
+	current, err := readBackup()
+	if err != nil {
+		deviceError(err)
+		return
+	}
+
+	if backup.Device.Serial != current.Device.Serial {
+		msg := fmt.Sprintf("The backup was taken from device %s, not %s.", backup.Device.Serial, current.Device.Serial)
+		if !gui.ConfirmDialog(win, "Restore backup of another device?", msg) {
+			return
+		}
+	}
+
+	changes := diffBackup(current, backup)
+	if len(changes) == 0 {
+		devLog().Info("Device already matches the backup", util.F("file", file))
+		return
+	}
+
+	if !gui.ReviewDialog(win, "Restore backup?", fmtChanges(changes)) {
+		return
+	}
+
+	descriptors, err := restoreBackup(current, backup)
+	if err != nil {
+		deviceError(err)
+		return
+	}
+
+	devLog().Info("Backup restored and verified", util.F("file", file))
+
+	if !descriptors {
+		reloadDevice()
+		return
+	}
+
+	// follow the device if it re-enumerates with restored USB IDs
+	if ids, _ := backup.ids(); ids != [2]usb.ID{micro.VendID, micro.ProdID} {
+		watchDevices(ids[0], ids[1])
+	}
+
+	manufact, product := conf.Manufact, conf.Product
+	afterReconnect = func() { verifyStrings(true, manufact, product) }
+	resetDevice()
 }
 
 // Disconnects USB device and quits the application.
@@ -195,92 +554,295 @@ func logInfo() {
 	buffer.SetText("")
 }
 
-// Sets LED configuration options.
-func configLED() (ledfunc, duration string) {
-	if opts.RxTGL == "0" || opts.TxTGL == "0" {
-		ledfunc = "blink"
-		if opts.LEDX == "0" {
-			duration = "100"
-		} else if opts.LEDX == "1" {
-			duration = "200"
-		}
-	} else if opts.RxTGL == "1" || opts.TxTGL == "1" {
-		ledfunc = "toggle"
+// Performs a USB port reset on the device. The device re-enumerates,
+// so its handle is closed and reconnect opens it again.
+func resetDevice() {
+	if !online {
+		return
+	}
+
+	if err := micro.Reload(); err != nil {
+		deviceError(err)
+		return
 	}
-	return
-}
 
-// Sets info console.
-func setConsole() {
-	info := " [INFO]	USB Device (Microchip MCP2200) Connected!\n"
-	info += " [Done]	Claiming HID Interface (2, 0)...\n"
-	info += " [DONE]	Reading String Descriptors...\n"
-	info += " [DONE]	Reading Device Serial Number...\n"
-	info += " [DONE]	Reading Device Configurations...\n"
-	buffer.SetText(info)
+	micro.Close()
+	setOnline(false)
+	devLog().Info("USB device reset")
+	reconnect()
 }
 
 // Reconnects USB device and reloads application
 func reloadApp() {
-	micro.Reload()
+	resetDevice()
+	listDevices()
 	logInfo()
-	setConsole()
+
+	if online {
+		logDevice()
+	} else {
+		afterReconnect = logDevice
+		reconnect()
+	}
 }
 
-func main() {
-	// create new usb.MCP object
-	micro = new(usb.MCP)
+// Fills the device selector with connected devices.
+// A failed scan leaves the selector empty.
+func listDevices() {
+	selecting = true
+	defer func() { selecting = false }()
+
+	list, _ := listKnown(devBackend)
+
+	combo.Device.RemoveAll()
+	for _, dev := range list {
+		combo.Device.Append(dev.Path, fmt.Sprintf("%s (%s)", dev.Serial, dev.Path))
+		if dev.Serial == conf.Serial {
+			combo.Device.SetActiveID(dev.Path)
+		}
+	}
+}
+
+// Opens the device chosen in the device selector.
+func selectDevice() {
+	path := combo.Device.GetActiveID()
+	if selecting || path == "" || (online && path == devFilter.Path) {
+		return
+	}
+
+	prev := micro
+	if err := openDevice(devBackend, usb.Filter{Path: path}); err != nil {
+		gui.ErrorDialog(win, "Could not open device", err.Error())
+		return
+	}
+
+	if online {
+		prev.Close()
+	}
+
+	showDevice()
+	setOnline(true)
+	logDevice()
+}
+
+// Fills all panels from the opened device.
+func showDevice() {
+	setWidgets()
+	setInfo()
+	setPins()
+}
+
+// Returns the filter finding the opened device again after it was
+// unplugged. The serial number is used if the device reports one.
+func deviceFilter() usb.Filter {
+	if conf.Serial != "" {
+		return usb.Filter{Serial: conf.Serial}
+	}
+	return usb.Filter{Path: devFilter.Path}
+}
+
+// Updates the widgets depending on the device connection state.
+func setOnline(state bool) {
+	online = state
+	gui.SetStatus(icon.Stat, online)
+	button.Config.SetSensitive(online)
+	button.Export.SetSensitive(online)
+	button.Backup.SetSensitive(online)
+	button.Restore.SetSensitive(online)
+	panel.GPIO.SetSensitive(online)
+}
+
+// Handles a failed device operation. A removed device is marked as
+// disconnected, other errors are logged and shown in a dialog.
+func deviceError(err error) {
+	if errors.Is(err, usb.ErrDisconnected) || errors.Is(err, usb.ErrNotFound) {
+		disconnect(err)
+		return
+	}
+
+	var mismatch usb.Mismatch
+	if errors.As(err, &mismatch) {
+		lines := make([]string, len(mismatch))
+		for i, change := range mismatch {
+			lines[i] = fmt.Sprintf("%s: wrote %s, read %s", change.Field, change.Old, change.New)
+			devLog().Error("Verification failed", util.F("field", change.Field), util.F("wrote", change.Old), util.F("read", change.New))
+		}
+		gui.ErrorDialog(win, "Verification failed", strings.Join(lines, "\n"))
+		return
+	}
+
+	devLog().Error("Device error", util.F("error", err))
+	gui.ErrorDialog(win, "Device error", err.Error())
+}
+
+// Handles a failed GPIO poll without flooding the console
+// with the same error on every poll.
+func pollError(err error) {
+	if errors.Is(err, usb.ErrDisconnected) || errors.Is(err, usb.ErrNotFound) {
+		disconnect(err)
+		return
+	}
+
+	if err.Error() != pollErr {
+		pollErr = err.Error()
+		devLog().Error("GPIO poll failed", util.F("error", pollErr))
+	}
+
+	// a device which is still listed but keeps failing is reopened
+	if pollFails++; pollFails >= pollRetries {
+		disconnect(err)
+	}
+}
+
+// Closes the device after it was removed or stopped answering and
+// starts reopening it.
+func disconnect(err error) {
+	if !online {
+		return
+	}
+
+	micro.Close()
+	setOnline(false)
+	pollFails = 0
+	devLog().Warn("USB device disconnected", util.F("error", err))
+
+	// an unplug and replug between two bus scans produces no event
+	reconnect()
+}
+
+// Reopens the device with the same serial number and re-reads its
+// configuration. Opening is retried until the device is back, with
+// growing delays while udev sets up access to the new device node or
+// the device stays unplugged.
+func reconnect() {
+	if online || reconnecting {
+		return
+	}
+
+	reconnecting = true
+	tryReconnect(reconnectInterval)
+}
+
+// Tries to reopen the device after delay milliseconds and schedules the
+// next attempt with twice the delay if it fails.
+func tryReconnect(delay uint) {
+	gui.Poll(delay, func() bool {
+		// another device was selected meanwhile
+		if online {
+			reconnecting = false
+			return false
+		}
 
-	// initialize a new usb context
-	micro.Context = usb.NewContext()
-	defer micro.Context.Close()
+		err := openDevice(devBackend, deviceFilter())
+		if err == nil {
+			reconnecting = false
+			showDevice()
+			setOnline(true)
+			devLog().Info("USB device reconnected")
+
+			if done := afterReconnect; done != nil {
+				afterReconnect = nil
+				done()
+			}
+			return false
+		}
 
-	// set Vendor/Product IDs for MCP2200 device
-	micro.VendID, micro.ProdID = 0x04D8, 0x00DF
+		next := delay * 2
+		if next >= reconnectMaxInterval {
+			if delay < reconnectMaxInterval {
+				devLog().Error("Could not reconnect, still trying", util.F("error", err))
+			}
+			next = reconnectMaxInterval
+		}
 
-	// open USB device with Vendor/Product ID
-	micro.Device = micro.OpenDevice(micro.VendID, micro.ProdID)
+		tryReconnect(next)
+		return false
+	})
+}
 
-	// enable Linux kernel driver auto detachment
-	micro.AutoDetach()
+// Handles devices attached or detached while the application runs.
+func hotplug(event usb.Event) {
+	listDevices()
 
-	// ***TO BE REMOVED***
-	//var vendID, prodID usb.ID  = 0x12D1, 0x1039
+	if !deviceFilter().Match(event.Device) {
+		return
+	}
 
-	conf = new(Conf)
-	vid, pid := uint16(micro.VendID), uint16(micro.ProdID)
-	conf.VendID, conf.ProdID = util.UintToStr(vid, pid)
+	if event.Attached {
+		reconnect()
+	} else {
+		disconnect(errors.New("device removed"))
+	}
+}
 
-	// check if the USB device is connected
-	if micro.Device == nil {
-		util.Fatalf("Matching USB device not found!\n[VendorID ProductID] %s %s", conf.VendID, conf.ProdID)
+// Forwards hotplug events of devices with given USB IDs to the GTK
+// main loop. A previously started watch is stopped.
+func watchDevices(VendID, ProdID usb.ID) {
+	if watchStop != nil {
+		close(watchStop)
 	}
-	defer micro.Device.Close()
+	watchStop = make(chan struct{})
+
+	events := usb.Watch(devBackend, VendID, ProdID, usb.WatchInterval, watchStop)
+
+	go func() {
+		for event := range events {
+			event := event
+			gui.Idle(func() { hotplug(event) })
+		}
+	}()
+}
 
-	// initialize device configuration
-	micro.Conf = micro.SelectConfig()
-	defer micro.Conf.Close()
+// Sets the info panel entries from device string descriptors.
+func setInfo() {
+	input.Manufacturer.SetText(conf.Manufact)
+	input.Product.SetText(conf.Product)
+	input.Serial.SetText(conf.Serial)
+}
+
+func main() {
+	// run headless command line interface if a subcommand is given
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
 
-	// claim HID interface
-	micro.Interface = micro.ClaimHIDInterface()
-	defer micro.Interface.Close()
+	runGUI()
+}
 
-	// set In/Out Endpoints
-	micro.InEP = micro.InEndpoint()
-	micro.OutEP = micro.OutEndpoint()
+// Reports a widget that could not be created and exits.
+func widgetFailed(err error) {
+	fail(exitFail, "could not create widgets: %v", err)
+	gui.ErrorDialog(win, "Could not create widgets", err.Error())
+	os.Exit(exitFail)
+}
 
-	// send READ_ALL command request to MCP2200
-	micro.ReadAllCmd()
-	// parse READ_ALL command response from MCP2200
-	micro.Data = micro.ParseResponse()
+// Builds the GTK window and runs the main loop.
+func runGUI() {
+	var err error
+	if win, err = gui.NewWin(); err != nil {
+		os.Exit(fail(exitFail, "could not create window: %v", err))
+	}
 
-	// read string descriptors
-	conf.Manufact = micro.ReadManufacturer()
-	conf.Product = micro.ReadProduct()
-	conf.Serial = micro.ReadSerial()
+	cfg := envLogConfig()
+	if err := setupLog(cfg, util.LevelWarn); err != nil {
+		gui.ErrorDialog(win, "Invalid log settings", err.Error())
+		os.Exit(exitUsage)
+	}
+
+	// open USB device and read its configuration
+	filter := usb.Filter{Serial: os.Getenv("MICROCONFIG_SERIAL"), Path: os.Getenv("MICROCONFIG_PATH")}
+	if err := openDevice(os.Getenv("MICROCONFIG_BACKEND"), filter); err != nil {
+		gui.ErrorDialog(win, "Could not open MCP2200 device", err.Error())
+		os.Exit(exitCode(err))
+	}
+	online = true
+	defer func() {
+		if online {
+			micro.Close()
+		}
+	}()
 
 	// init widget objects
-	win = gui.NewWin()
 	panel = new(Panel)
 	button = new(Button)
 	icon = new(Icon)
@@ -290,88 +852,83 @@ func main() {
 	spin = new(Spin)
 	toggle = new(Toggle)
 	console = new(Console)
+	pins = new(Pins)
 
 	// set headerbar widgets
-	panel.Header, button.Import, button.Export, button.Reload, button.Quit = gui.HeaderBar()
+	panel.Header, combo.Device, button.Import, button.Export, button.Backup, button.Restore, button.Reload, button.Quit, err = gui.HeaderBar()
+	if err != nil {
+		widgetFailed(err)
+	}
+	listDevices()
 
 	// set config panel widgets
-	panel.Conf, input.VendID, input.ProdID, combo.BaudRate, input.IOConf, input.OutDef,
+	panel.Conf, input.VendID, input.ProdID, combo.BaudRate, combo.BaudInfo, input.IOConf, input.OutDef,
 		toggle.Leds, toggle.Pins, toggle.Usbcfg, toggle.Suspend, toggle.UPol, radio.BlinkLeds,
-		radio.ToggleLeds, spin.Duration, button.Config, button.Reset = gui.ConfigPanel()
-
-	// set IDs
-	input.VendID.SetText(conf.VendID)
-	input.ProdID.SetText(conf.ProdID)
-
-	// parse Alt_Opts and Alt_Pins data
-	opts = micro.ParseAltOpts(micro.Data.Alt_Opts)
-	gpio = micro.ParseAltPins(micro.Data.Alt_Pins)
-
-	// set Conf
-	baud := micro.GetBaudRate(micro.Data.Baud_Rate_H, micro.Data.Baud_Rate_L)
-	conf.BaudRate = util.IntToStr(baud)
-	conf.IOConfig = util.FmtBits(micro.Data.IO_Bmap)
-	conf.OutDefault = util.FmtBits(micro.Data.IO_Default)
-	conf.TxRxLeds = gpio.TxLED
-	conf.CRTS = opts.HW_Flow
-	conf.USBCFG = gpio.USBCFG
-	conf.Suspend = gpio.SSPND
-	conf.UARTPol = opts.Invert
+		radio.ToggleLeds, spin.Duration, button.Config, button.Reset, err = gui.ConfigPanel()
+	if err != nil {
+		widgetFailed(err)
+	}
 
-	// set LED configuration options
-	conf.LedFunc, conf.Blink = configLED()
+	// offer standard baud rates, others can be typed in
+	for _, rate := range usb.BaudRates {
+		baud := util.IntToStr(rate)
+		combo.BaudRate.Append(baud, baud)
+	}
 
 	// set active vals
-	index := micro.GetBaudRateIndex(conf.BaudRate)
-	combo.BaudRate.SetActive(index)
-	input.IOConf.SetText(conf.IOConfig)
-	input.OutDef.SetText(conf.OutDefault)
+	setWidgets()
+	showBaud()
 
-	if conf.TxRxLeds == "1" {
-		toggle.Leds.SetActive(true)
-	} else {
-		toggle.Leds.SetActive(false)
+	// set GPIO panel widgets
+	if panel.GPIO, pins.Dir, pins.Level, pins.Out, err = gui.GPIOPanel(); err != nil {
+		widgetFailed(err)
 	}
+	setPins()
 
-	if conf.USBCFG == "1" {
-		toggle.Usbcfg.SetActive(true)
-	} else {
-		toggle.Usbcfg.SetActive(false)
+	// set info panel widgets
+	panel.Info, icon.Stat, input.Manufacturer, input.Product, input.Serial, button.Console, console.View, err = gui.InfoPanel(conf.Manufact, conf.Product, conf.Serial)
+	if err != nil {
+		widgetFailed(err)
 	}
-
-	if conf.Suspend == "1" {
-		toggle.Suspend.SetActive(true)
-	} else {
-		toggle.Suspend.SetActive(false)
+	if buffer, err = gui.GetBuffer(console.View); err != nil {
+		widgetFailed(err)
 	}
-
-	// set active radio buttons
-	if conf.LedFunc == "blink" {
-		radio.BlinkLeds.SetActive(true)
-	} else if conf.LedFunc == "toggle" {
-		radio.ToggleLeds.SetActive(true)
+	if err := addConsoleSink(cfg); err != nil {
+		gui.ErrorDialog(win, "Invalid log settings", err.Error())
+		os.Exit(exitUsage)
 	}
-
-	// set info panel widgets
-	panel.Info, icon.Stat, input.Manufacturer, input.Product, input.Serial, button.Console, console.View = gui.InfoPanel(conf.Manufact, conf.Product, conf.Serial)
-	buffer = gui.GetBuffer(console.View)
-	setConsole()
+	logDevice()
 
 	// wrap panels inside a root box
-	rootBox := gui.RootBox(panel.Conf, panel.Info)
+	rootBox, err := gui.RootBox(panel.Conf, panel.GPIO, panel.Info)
+	if err != nil {
+		widgetFailed(err)
+	}
 
 	// handle button click events
+	combo.Device.Connect("changed", selectDevice)
+	combo.BaudRate.Connect("changed", showBaud)
 	button.Config.Connect("clicked", configDevice)
-	button.Reset.Connect("clicked", micro.Reload)
-	button.Import.Connect("clicked", importXML)
-	button.Export.Connect("clicked", exportXML)
+	button.Reset.Connect("clicked", resetDevice)
+	button.Import.Connect("clicked", importProfile)
+	button.Export.Connect("clicked", exportProfile)
+	button.Backup.Connect("clicked", backupDevice)
+	button.Restore.Connect("clicked", restoreDevice)
 	button.Reload.Connect("clicked", reloadApp)
 	button.Quit.Connect("clicked", quitApp)
 	button.Console.Connect("clicked", logInfo)
 
+	// handle GPIO switch events and poll pin levels
+	for pin := 0; pin < usb.NumPins; pin++ {
+		pin := pin
+		pins.Out[pin].Connect("notify::active", func() { drivePin(pin) })
+	}
+	gui.Poll(pollInterval, pollPins)
+
+	// track device connection state
+	watchDevices(micro.VendID, micro.ProdID)
+
 	button.Reset.SetSensitive(false)
-	button.Import.SetSensitive(false)
-	button.Export.SetSensitive(false)
 
 	// render window with the widgets
 	gui.Render(win, panel.Header, rootBox)
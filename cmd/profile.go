@@ -0,0 +1,301 @@
+// Versioned device configuration profiles.
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"text/template"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Version of the profile schema written by this release.
+const profileVersion = 1
+
+// Represents a device configuration profile stored on disk.
+type Profile struct {
+	XMLName    xml.Name       `xml:"microconfig" json:"-" yaml:"-" toml:"-"`
+	Version    int            `xml:"version,attr" json:"version" yaml:"version" toml:"version"`
+	Device     ProfileDevice  `xml:"device" json:"device" yaml:"device" toml:"device"`
+	UART       ProfileUART    `xml:"uart" json:"uart" yaml:"uart" toml:"uart"`
+	GPIO       ProfileGPIO    `xml:"gpio" json:"gpio" yaml:"gpio" toml:"gpio"`
+	AltPins    ProfileAltPins `xml:"alt_pins" json:"alt_pins" yaml:"alt_pins" toml:"alt_pins"`
+	AltOptions ProfileAltOpts `xml:"alt_options" json:"alt_options" yaml:"alt_options" toml:"alt_options"`
+	Variables  []ProfileVar   `xml:"variable,omitempty" json:"variables,omitempty" yaml:"variables,omitempty" toml:"variables,omitempty"`
+}
+
+// Represents USB IDs and string descriptors of a profile.
+type ProfileDevice struct {
+	VendID   string `xml:"vendor_id" json:"vendor_id" yaml:"vendor_id" toml:"vendor_id"`
+	ProdID   string `xml:"product_id" json:"product_id" yaml:"product_id" toml:"product_id"`
+	Manufact string `xml:"manufacturer" json:"manufacturer" yaml:"manufacturer" toml:"manufacturer"`
+	Product  string `xml:"product" json:"product" yaml:"product" toml:"product"`
+	Serial   string `xml:"serial" json:"serial" yaml:"serial" toml:"serial"`
+}
+
+// Represents a variable used in string descriptor templates.
+type ProfileVar struct {
+	Name  string `xml:"name,attr" json:"name" yaml:"name" toml:"name"`
+	Value string `xml:",chardata" json:"value" yaml:"value" toml:"value"`
+}
+
+// Represents UART settings of a profile.
+type ProfileUART struct {
+	BaudRate string `xml:"baud_rate" json:"baud_rate" yaml:"baud_rate" toml:"baud_rate"`
+}
+
+// Represents GPIO power-on settings of a profile.
+type ProfileGPIO struct {
+	IOConfig   string `xml:"io_config" json:"io_config" yaml:"io_config" toml:"io_config"`
+	OutDefault string `xml:"output_default" json:"output_default" yaml:"output_default" toml:"output_default"`
+}
+
+// Represents Config_Alt_Pins settings of a profile.
+type ProfileAltPins struct {
+	TxRxLeds string `xml:"txrx_leds" json:"txrx_leds" yaml:"txrx_leds" toml:"txrx_leds"`
+	USBCFG   string `xml:"usbcfg" json:"usbcfg" yaml:"usbcfg" toml:"usbcfg"`
+	Suspend  string `xml:"suspend" json:"suspend" yaml:"suspend" toml:"suspend"`
+}
+
+// Represents Config_Alt_Options settings of a profile.
+type ProfileAltOpts struct {
+	CRTS    string `xml:"hw_flow" json:"hw_flow" yaml:"hw_flow" toml:"hw_flow"`
+	UARTPol string `xml:"invert" json:"invert" yaml:"invert" toml:"invert"`
+	LedFunc string `xml:"led_function" json:"led_function" yaml:"led_function" toml:"led_function"`
+	Blink   string `xml:"blink_duration" json:"blink_duration" yaml:"blink_duration" toml:"blink_duration"`
+}
+
+// Creates a profile from given configuration.
+func newProfile(c *Conf) *Profile {
+	profile := new(Profile)
+	profile.Version = profileVersion
+	profile.Device = ProfileDevice{c.VendID, c.ProdID, c.Manufact, c.Product, c.Serial}
+	profile.UART = ProfileUART{c.BaudRate}
+	profile.GPIO = ProfileGPIO{c.IOConfig, c.OutDefault}
+	profile.AltPins = ProfileAltPins{c.TxRxLeds, c.USBCFG, c.Suspend}
+	profile.AltOptions = ProfileAltOpts{c.CRTS, c.UARTPol, c.LedFunc, c.Blink}
+	return profile
+}
+
+// Returns the settings of the device configuration in data that a profile
+// can not represent: baud rates out of range and LED bits that differ
+// between Tx and Rx, which profiles set together.
+func profileWarnings(data *usb.Data) []string {
+	var warnings []string
+
+	if baud := usb.BaudFromBytes(data.Baud_Rate_H, data.Baud_Rate_L); !baud.InRange() {
+		warnings = append(warnings, fmt.Sprintf("baud rate %d is outside %d-%d, the profile can not be imported",
+			baud.Rate, usb.MinBaudRate, usb.MaxBaudRate))
+	}
+
+	opts, pins := new(usb.AltOpts), new(usb.AltPins)
+	opts.UnmarshalByte(data.Alt_Opts)
+	pins.UnmarshalByte(data.Alt_Pins)
+
+	if pins.TxLED != pins.RxLED {
+		warnings = append(warnings, fmt.Sprintf("TxLED and RxLED differ, the profile sets both to %s", bitStr(pins.TxLED)))
+	}
+	if opts.TxTGL != opts.RxTGL {
+		warnings = append(warnings, "TxTGL and RxTGL differ, the profile sets both LEDs to toggle")
+	}
+
+	return warnings
+}
+
+// Returns the configuration held by the profile.
+func (profile *Profile) Conf() *Conf {
+	c := new(Conf)
+	c.VendID, c.ProdID = profile.Device.VendID, profile.Device.ProdID
+	c.Manufact, c.Product, c.Serial = profile.Device.Manufact, profile.Device.Product, profile.Device.Serial
+	c.BaudRate = profile.UART.BaudRate
+	c.IOConfig, c.OutDefault = profile.GPIO.IOConfig, profile.GPIO.OutDefault
+	c.TxRxLeds, c.USBCFG, c.Suspend = profile.AltPins.TxRxLeds, profile.AltPins.USBCFG, profile.AltPins.Suspend
+	c.CRTS, c.UARTPol = profile.AltOptions.CRTS, profile.AltOptions.UARTPol
+	c.LedFunc, c.Blink = profile.AltOptions.LedFunc, profile.AltOptions.Blink
+	return c
+}
+
+// Reads and validates a profile file.
+// The format is picked by file extension: .xml, .json, .yaml/.yml or .toml.
+func loadProfile(filename string) (*Profile, error) {
+	profile := new(Profile)
+	if err := util.ImportFile(filename, profile); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if profile.Version == 0 {
+		return nil, fmt.Errorf("%s: missing profile version", filename)
+	}
+
+	if profile.Version > profileVersion {
+		return nil, fmt.Errorf("%s: unsupported profile version %d", filename, profile.Version)
+	}
+
+	// string descriptors are checked once their templates are expanded
+	c := profile.Conf()
+	for _, str := range []string{c.Manufact, c.Product} {
+		if _, err := template.New("").Parse(str); err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	c.Manufact, c.Product = "", ""
+
+	if err := validateConf(c, nil); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return profile, nil
+}
+
+// Writes the profile to a file in the format given by file extension.
+func saveProfile(filename string, profile *Profile) error {
+	return util.ExportFile(filename, profile)
+}
+
+// Copies profile settings into Conf and expands string descriptor
+// templates. Variables in vars override those of the profile.
+// The serial number identifies the device and is never taken from a profile.
+// Empty string descriptors keep the strings of the device.
+func applyProfile(profile *Profile, vars map[string]string) error {
+	c, err := profileConf(profile, vars, conf)
+	if err != nil {
+		return err
+	}
+
+	conf = c
+	return nil
+}
+
+// Returns the configuration a profile assigns to the device described by
+// dev, with string descriptor templates expanded and validated.
+func profileConf(profile *Profile, vars map[string]string, dev *Conf) (*Conf, error) {
+	c := profile.Conf()
+	c.Serial = dev.Serial
+
+	data := templateVars(profile, vars, dev.Serial)
+	for _, str := range []*string{&c.Manufact, &c.Product} {
+		expanded, err := expandString(*str, data)
+		if err != nil {
+			return nil, err
+		}
+		*str = expanded
+	}
+
+	if c.Manufact == "" {
+		c.Manufact = dev.Manufact
+	}
+	if c.Product == "" {
+		c.Product = dev.Product
+	}
+
+	if err := validateConf(c, nil); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Returns the values available to string descriptor templates:
+// serial, vendor_id, product_id and the profile variables.
+func templateVars(profile *Profile, vars map[string]string, serial string) map[string]string {
+	data := map[string]string{
+		"serial":     serial,
+		"vendor_id":  profile.Device.VendID,
+		"product_id": profile.Device.ProdID,
+	}
+
+	for _, v := range profile.Variables {
+		data[v.Name] = v.Value
+	}
+
+	for name, value := range vars {
+		data[name] = value
+	}
+
+	return data
+}
+
+// Expands a string descriptor template such as "Widget rev {{.revision}}".
+func expandString(str string, data map[string]string) (string, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Parse(str)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Parses a baud rate and computes its divisor.
+func parseBaud(str string) (usb.Baud, error) {
+	rate, err := strconv.Atoi(str)
+	if err != nil {
+		return usb.Baud{}, fmt.Errorf("invalid baud rate %q", str)
+	}
+	return usb.NewBaud(rate)
+}
+
+// Returns the baud rate setting of a configuration. If data is given and
+// the rate equals the one it holds, the divisor read from the device is
+// kept, even when it lies outside the range NewBaud accepts.
+func confBaud(c *Conf, data *usb.Data) (usb.Baud, error) {
+	if data != nil {
+		if baud := usb.BaudFromBytes(data.Baud_Rate_H, data.Baud_Rate_L); c.BaudRate == util.IntToStr(baud.Rate) {
+			return baud, nil
+		}
+	}
+	return parseBaud(c.BaudRate)
+}
+
+// Checks that the Conf fields hold valid values. The baud rate of the
+// device configuration in data is accepted as is, data may be nil.
+func validateConf(c *Conf, data *usb.Data) error {
+	for _, id := range []string{c.VendID, c.ProdID} {
+		if _, err := strconv.ParseUint(id, 0, 16); err != nil {
+			return fmt.Errorf("invalid USB ID %q", id)
+		}
+	}
+
+	if _, err := confBaud(c, data); err != nil {
+		return err
+	}
+
+	for _, bits := range []string{c.IOConfig, c.OutDefault} {
+		if _, err := parseBitmap(bits); err != nil {
+			return err
+		}
+	}
+
+	for _, bit := range []string{c.TxRxLeds, c.USBCFG, c.Suspend, c.CRTS, c.UARTPol} {
+		if bit != "0" && bit != "1" {
+			return fmt.Errorf("invalid switch value %q", bit)
+		}
+	}
+
+	if c.LedFunc != "blink" && c.LedFunc != "toggle" {
+		return fmt.Errorf("invalid LED function %q", c.LedFunc)
+	}
+
+	if c.Blink != "100" && c.Blink != "200" {
+		return fmt.Errorf("invalid blink duration %q", c.Blink)
+	}
+
+	for _, str := range []string{c.Manufact, c.Product} {
+		if str == "" {
+			continue
+		}
+		if err := usb.ValidateString(str); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,274 @@
+// Batch provisioning of all connected devices.
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Number of devices configured at the same time by default.
+const provisionJobs = 4
+
+// Represents the settings of a single device in an overrides file.
+type Override struct {
+	Serial    string       `xml:"serial,attr" json:"serial" yaml:"serial" toml:"serial"`
+	Profile   string       `xml:"profile,omitempty" json:"profile,omitempty" yaml:"profile,omitempty" toml:"profile,omitempty"`
+	Variables []ProfileVar `xml:"variable,omitempty" json:"variables,omitempty" yaml:"variables,omitempty" toml:"variables,omitempty"`
+}
+
+// Represents per-serial overrides of a provisioning run.
+type Overrides struct {
+	XMLName xml.Name   `xml:"overrides" json:"-" yaml:"-" toml:"-"`
+	Devices []Override `xml:"device" json:"devices" yaml:"devices" toml:"devices"`
+}
+
+// Represents the outcome of provisioning a single device.
+type ProvisionResult struct {
+	Serial  string       `json:"serial"`
+	Path    string       `json:"path"`
+	VendID  string       `json:"vendor_id"`
+	ProdID  string       `json:"product_id"`
+	Profile string       `json:"profile"`
+	Status  string       `json:"status"`
+	Changes []usb.Change `json:"changes"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// define provisioning result states
+const (
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+// Represents the profile, template variables and optional EEPROM image
+// assigned to a device. The USB IDs of the profile are only programmed
+// if ids is set.
+type assignment struct {
+	file    string
+	profile *Profile
+	vars    map[string]string
+	eeprom  []byte
+	ids     bool
+}
+
+// Opens a listed device through the backend.
+func openInfo(backend string, info usb.DeviceInfo) (*usb.MCP, error) {
+	filter := usb.Filter{Serial: info.Serial, Path: info.Path}
+	transport, err := usb.Open(backend, info.VendID, info.ProdID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &usb.MCP{Transport: transport, VendID: info.VendID, ProdID: info.ProdID}, nil
+}
+
+// Returns a file path relative to the directory of another file.
+func relPath(base, file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(base), file)
+}
+
+// Loads an overrides file and the profiles it refers to. Profile paths
+// are relative to the directory of the overrides file.
+func loadOverrides(filename string, vars map[string]string) (map[string]assignment, error) {
+	overrides := new(Overrides)
+	if err := util.ImportFile(filename, overrides); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	assigned := make(map[string]assignment)
+	for _, o := range overrides.Devices {
+		if o.Serial == "" {
+			return nil, fmt.Errorf("%s: override without serial number", filename)
+		}
+
+		a := assignment{vars: make(map[string]string)}
+		for name, value := range vars {
+			a.vars[name] = value
+		}
+		for _, v := range o.Variables {
+			a.vars[v.Name] = v.Value
+		}
+
+		if o.Profile != "" {
+			a.file = relPath(filename, o.Profile)
+
+			profile, err := loadProfile(a.file)
+			if err != nil {
+				return nil, err
+			}
+			a.profile = profile
+		}
+
+		assigned[o.Serial] = a
+	}
+
+	return assigned, nil
+}
+
+// Applies the assigned profile and EEPROM image to a single device and
+// verifies them.
+// If reset is set, the device is reset and reopened to verify new
+// string descriptors and USB IDs.
+func provisionDevice(backend string, info usb.DeviceInfo, a assignment, reset bool) ProvisionResult {
+	result := ProvisionResult{Serial: info.Serial, Path: info.Path, Profile: a.file}
+	result.VendID, result.ProdID = util.UintToStr(uint16(info.VendID), uint16(info.ProdID))
+
+	fail := func(err error) ProvisionResult {
+		result.Status, result.Error = statusFailed, err.Error()
+		return result
+	}
+
+	dev, err := openInfo(backend, info)
+	if err != nil {
+		return fail(err)
+	}
+	// a reset replaces dev with a new handle, or nil if reopening failed
+	defer func() {
+		if dev != nil {
+			dev.Close()
+		}
+	}()
+
+	current, err := readConf(dev)
+	if err != nil {
+		return fail(err)
+	}
+
+	c, err := profileConf(a.profile, a.vars, current)
+	if err != nil {
+		return fail(err)
+	}
+
+	vid, pid, err := confIDs(c)
+	if err != nil {
+		return fail(err)
+	}
+	newIDs := vid != dev.VendID || pid != dev.ProdID
+	if newIDs && !a.ids {
+		change := idChange(dev, vid, pid)
+		return fail(fmt.Errorf("profile changes the USB IDs from %s to %s, which are only programmed when asked for", change.Old, change.New))
+	}
+
+	old := *dev.Data
+	if err := encodeConf(c, dev.Data); err != nil {
+		return fail(err)
+	}
+
+	if result.Changes, err = confChanges(dev, c); err != nil {
+		return fail(err)
+	}
+
+	// devices already configured are left alone to save write cycles
+	if len(usb.DiffConfig(&old, dev.Data)) > 0 {
+		if err := dev.WriteConfig(); err != nil {
+			return fail(err)
+		}
+	}
+
+	if a.eeprom != nil {
+		image, err := dev.DumpEEPROM()
+		if err != nil {
+			return fail(err)
+		}
+		result.Changes = append(result.Changes, diffEEPROM(image, a.eeprom)...)
+
+		if err := dev.LoadEEPROM(a.eeprom); err != nil {
+			return fail(err)
+		}
+	}
+
+	written, err := writeConfStrings(dev, c)
+	if err != nil {
+		return fail(err)
+	}
+
+	if newIDs {
+		result.Changes = append(result.Changes, idChange(dev, vid, pid))
+
+		if err := programIDs(dev, vid, pid); err != nil {
+			return fail(err)
+		}
+	}
+
+	if (written || newIDs) && reset {
+		if dev, err = reopenAfterReset(backend, dev, usb.Filter{Serial: info.Serial, Path: info.Path}); err != nil {
+			return fail(err)
+		}
+
+		if err := dev.VerifyStrings(c.Manufact, c.Product); err != nil {
+			return fail(err)
+		}
+
+		if dev.VendID != vid || dev.ProdID != pid {
+			return fail(fmt.Errorf("device came back with USB IDs %s", idChange(dev, vid, pid).Old))
+		}
+	}
+
+	result.Status = statusOK
+	return result
+}
+
+// Provisions all devices using up to jobs workers. Results are returned
+// in the order of the device list.
+func provisionAll(backend string, list []usb.DeviceInfo, assign func(usb.DeviceInfo) assignment, jobs int, reset bool) []ProvisionResult {
+	results := make([]ProvisionResult, len(list))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range queue {
+				results[n] = provisionDevice(backend, list[n], assign(list[n]), reset)
+			}
+		}()
+	}
+
+	for n := range list {
+		queue <- n
+	}
+	close(queue)
+	wg.Wait()
+
+	return results
+}
+
+// Writes provisioning results as CSV, one device per row.
+func writeCSVReport(filename string, results []ProvisionResult) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"serial", "path", "vendor_id", "product_id", "profile", "status", "changes", "error"})
+
+	for _, r := range results {
+		changes := make([]string, len(r.Changes))
+		for i, change := range r.Changes {
+			changes[i] = change.String()
+		}
+		w.Write([]string{r.Serial, r.Path, r.VendID, r.ProdID, r.Profile, r.Status, strings.Join(changes, "; "), r.Error})
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,152 @@
+// Partial configuration updates applied by read-modify-write.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/korayeyinc/microconfig/usb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Parses an on/off switch value.
+func parseSwitch(str string) (bool, error) {
+	switch strings.ToLower(str) {
+	case "on", "1", "true", "yes":
+		return true, nil
+	case "off", "0", "false", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid switch value %q", str)
+}
+
+// Parses an 8-bit bitmap such as 11110000.
+func parseBitmap(str string) (uint8, error) {
+	if len(str) != 8 || util.FmtBits(util.BitsToUint8(str)) != str {
+		return 0, fmt.Errorf("invalid bitmap %q", str)
+	}
+	return util.BitsToUint8(str), nil
+}
+
+// Applies a single NAME=VALUE edit to the configuration. Bits not named
+// by the edit, including reserved bits, are kept as they are.
+func setField(data *usb.Data, name, value string) error {
+	opts, pins := new(usb.AltOpts), new(usb.AltPins)
+	opts.UnmarshalByte(data.Alt_Opts)
+	pins.UnmarshalByte(data.Alt_Pins)
+
+	switches := map[string][]*bool{
+		"leds":    {&pins.TxLED, &pins.RxLED},
+		"usbcfg":  {&pins.USBCFG},
+		"suspend": {&pins.SSPND},
+		"flow":    {&opts.HW_Flow},
+		"invert":  {&opts.Invert},
+	}
+
+	var err error
+	switch {
+	case switches[name] != nil:
+		var on bool
+		if on, err = parseSwitch(value); err != nil {
+			return err
+		}
+		for _, bit := range switches[name] {
+			*bit = on
+		}
+	case name == "baud":
+		var baud usb.Baud
+		if baud, err = parseBaud(value); err != nil {
+			return err
+		}
+		data.Baud_Rate_H, data.Baud_Rate_L = baud.Bytes()
+	case name == "io":
+		if data.IO_Bmap, err = parseBitmap(value); err != nil {
+			return err
+		}
+	case name == "default":
+		if data.IO_Default, err = parseBitmap(value); err != nil {
+			return err
+		}
+	case name == "ledfunc":
+		if value != "blink" && value != "toggle" {
+			return fmt.Errorf("invalid LED function %q", value)
+		}
+		opts.RxTGL, opts.TxTGL = value == "toggle", value == "toggle"
+	case name == "blink":
+		if value != "100" && value != "200" {
+			return fmt.Errorf("invalid blink duration %q", value)
+		}
+		opts.LEDX = value == "200"
+	case strings.HasPrefix(name, "gp"):
+		pin, err := strconv.Atoi(strings.TrimPrefix(name, "gp"))
+		if err != nil || pin < 0 || pin >= usb.NumPins {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		switch value {
+		case "input":
+			data.IO_Bmap |= usb.PinMask(pin)
+		case "output":
+			data.IO_Bmap &^= usb.PinMask(pin)
+		default:
+			return fmt.Errorf("invalid pin direction %q, want input or output", value)
+		}
+	default:
+		return fmt.Errorf("unknown field %q", name)
+	}
+
+	data.Alt_Pins = pins.MarshalByte()
+	data.Alt_Opts = opts.MarshalByte()
+
+	return nil
+}
+
+// Changes only the named fields: reads the configuration with READ_ALL,
+// applies the edits, then writes and verifies it.
+func setCmd(args []string) int {
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	dry := flags.Bool("dry-run", false, "show the changes and the CONFIGURE report without sending it")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if flags.NArg() == 0 {
+		return fail(exitUsage, "usage: set [-dry-run] NAME=VALUE...")
+	}
+
+	current, err := micro.ReadAll()
+	if err != nil {
+		return failErr(err)
+	}
+
+	data := *current
+	for _, arg := range flags.Args() {
+		i := strings.Index(arg, "=")
+		if i < 1 {
+			return fail(exitUsage, "invalid edit %q, want NAME=VALUE", arg)
+		}
+		if err := setField(&data, strings.ToLower(arg[:i]), arg[i+1:]); err != nil {
+			return fail(exitUsage, "%v", err)
+		}
+	}
+	micro.Data = &data
+
+	if *dry {
+		return dryRun()
+	}
+
+	changes := usb.DiffConfig(current, &data)
+	if len(changes) == 0 {
+		fmt.Println("No changes")
+		return exitOK
+	}
+
+	if err := micro.WriteConfig(); err != nil {
+		return failErr(err)
+	}
+
+	fmt.Println(fmtChanges(changes))
+	return exitOK
+}
@@ -0,0 +1,82 @@
+// Read-only check of a device against a profile.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/korayeyinc/microconfig/usb"
+)
+
+// Represents the comparison of a single profile field with the device.
+type FieldCheck struct {
+	Field string
+	Want  string
+	Got   string
+	Pass  bool
+}
+
+// Reports whether two USB IDs given as strings are equal.
+func sameID(want, got string) bool {
+	a, errA := parseID(want)
+	b, errB := parseID(got)
+	return errA == nil && errB == nil && a == b
+}
+
+// Reports whether a baud rate uses the divisor of the device configuration
+// in data. Only the wanted rate is parsed, the device divisor is taken as
+// is even if it lies outside the supported range.
+func sameBaud(want string, data *usb.Data) bool {
+	baud, err := parseBaud(want)
+	return err == nil && baud.Divisor == usb.BaudFromBytes(data.Baud_Rate_H, data.Baud_Rate_L).Divisor
+}
+
+// Compares every field of the wanted configuration with the device
+// configuration got, read along with the CONFIGURE data in data.
+// Fields are named as in profiles.
+func checkConf(want, got *Conf, data *usb.Data) []FieldCheck {
+	equal := func(a, b string) bool { return a == b }
+	baud := func(a, _ string) bool { return sameBaud(a, data) }
+
+	fields := []struct {
+		name      string
+		want, got string
+		same      func(a, b string) bool
+	}{
+		{"vendor_id", want.VendID, got.VendID, sameID},
+		{"product_id", want.ProdID, got.ProdID, sameID},
+		{"manufacturer", want.Manufact, got.Manufact, equal},
+		{"product", want.Product, got.Product, equal},
+		{"baud_rate", want.BaudRate, got.BaudRate, baud},
+		{"io_config", want.IOConfig, got.IOConfig, equal},
+		{"output_default", want.OutDefault, got.OutDefault, equal},
+		{"txrx_leds", want.TxRxLeds, got.TxRxLeds, equal},
+		{"usbcfg", want.USBCFG, got.USBCFG, equal},
+		{"suspend", want.Suspend, got.Suspend, equal},
+		{"hw_flow", want.CRTS, got.CRTS, equal},
+		{"invert", want.UARTPol, got.UARTPol, equal},
+		{"led_function", want.LedFunc, got.LedFunc, equal},
+		{"blink_duration", want.Blink, got.Blink, equal},
+	}
+
+	checks := make([]FieldCheck, len(fields))
+	for i, f := range fields {
+		checks[i] = FieldCheck{f.name, f.want, f.got, f.same(f.want, f.got)}
+	}
+
+	return checks
+}
+
+// Prints one line per field and reports whether all fields passed.
+func printChecks(checks []FieldCheck) bool {
+	pass := true
+	for _, check := range checks {
+		if check.Pass {
+			fmt.Printf("PASS %-16s %s\n", check.Field, check.Got)
+			continue
+		}
+		pass = false
+		fmt.Printf("FAIL %-16s expected %s, found %s\n", check.Field, check.Want, check.Got)
+	}
+	return pass
+}
@@ -3,8 +3,10 @@
 package gui
 
 import (
+	"fmt"
+
+	"github.com/gotk3/gotk3/glib"
 	"github.com/gotk3/gotk3/gtk"
-	"github.com/korayeyinc/microconfig/util"
 )
 
 type Window = *gtk.Window
@@ -21,6 +23,7 @@ type Spin = *gtk.SpinButton
 type Toggle = *gtk.Switch
 type TextView = *gtk.TextView
 type TextBuffer = *gtk.TextBuffer
+type TextLabel = *gtk.Label
 
 // Quit event handler.
 func Quit() {
@@ -28,249 +31,312 @@ func Quit() {
 }
 
 // Creates a new window.
-func NewWin() *gtk.Window {
+func NewWin() (*gtk.Window, error) {
 	gtk.Init(nil)
 	win, err := gtk.WindowNew(gtk.WINDOW_TOPLEVEL)
-	util.Check(err)
+	if err != nil {
+		return nil, err
+	}
 	win.SetTitle("Microconfig")
 	win.Connect("destroy", Quit)
-	return win
+	return win, nil
 }
 
 // Creates a new image from icon.
-func NewIcon(icon string) *gtk.Image {
-	img, err := gtk.ImageNewFromIconName(icon, gtk.ICON_SIZE_LARGE_TOOLBAR)
-	util.Check(err)
-	return img
+func NewIcon(icon string) (*gtk.Image, error) {
+	return gtk.ImageNewFromIconName(icon, gtk.ICON_SIZE_LARGE_TOOLBAR)
 }
 
 // Adds a new button widget.
-func NewButton(icon string) *gtk.Button {
-	button, err := gtk.ButtonNewFromIconName(icon, gtk.ICON_SIZE_LARGE_TOOLBAR)
-	util.Check(err)
-	return button
+func NewButton(icon string) (*gtk.Button, error) {
+	return gtk.ButtonNewFromIconName(icon, gtk.ICON_SIZE_LARGE_TOOLBAR)
+}
+
+// Adds a new button widget with icon and label.
+func labelButton(icon, label string) (*gtk.Button, error) {
+	button, err := NewButton(icon)
+	if err != nil {
+		return nil, err
+	}
+	button.SetLabel(label)
+	return button, nil
 }
 
 // Adds a new ComboBox widget.
-func ComboBox() *gtk.ComboBoxText {
-	combo, err := gtk.ComboBoxTextNew()
-	util.Check(err)
-	return combo
+func ComboBox() (*gtk.ComboBoxText, error) {
+	return gtk.ComboBoxTextNew()
+}
+
+// Adds a new ComboBox widget with an editable entry.
+func ComboBoxEntry() (*gtk.ComboBoxText, error) {
+	return gtk.ComboBoxTextNewWithEntry()
+}
+
+// Selects the item with given ID in a combo box. Text without a matching
+// item is typed into the entry of an editable combo box.
+func SetComboText(combo Combo, text string) {
+	if combo.SetActiveID(text) {
+		return
+	}
+
+	entry, err := combo.GetEntry()
+	if err != nil {
+		return
+	}
+	entry.SetText(text)
 }
 
 // Adds a new switch button widget.
-func NewToggle() *gtk.Switch {
-	toggle, err := gtk.SwitchNew()
-	util.Check(err)
-	return toggle
+func NewToggle() (*gtk.Switch, error) {
+	return gtk.SwitchNew()
 }
 
 // Adds a new inputbox widget.
-func InputBox() *gtk.Entry {
-	inbox, err := gtk.EntryNew()
-	util.Check(err)
-	return inbox
+func InputBox() (*gtk.Entry, error) {
+	return gtk.EntryNew()
+}
+
+// Adds a new inputbox widget with length limit, width and text.
+func newEntry(maxLength, width int, text string) (*gtk.Entry, error) {
+	inbox, err := InputBox()
+	if err != nil {
+		return nil, err
+	}
+	inbox.SetMaxLength(maxLength)
+	inbox.SetWidthChars(width)
+	inbox.SetText(text)
+	return inbox, nil
 }
 
 // Adds a new label widget.
-func Label(text string) *gtk.Label {
-	label, err := gtk.LabelNew(text)
-	util.Check(err)
-	return label
+func Label(text string) (*gtk.Label, error) {
+	return gtk.LabelNew(text)
 }
 
 // Adds a new radio button.
-func RadioButtons(label1, label2 string) (opt1, opt2 *gtk.RadioButton) {
-	var err error
-	opt1, err = gtk.RadioButtonNewWithLabel(nil, label1)
-	util.Check(err)
+func RadioButtons(label1, label2 string) (opt1, opt2 *gtk.RadioButton, err error) {
+	if opt1, err = gtk.RadioButtonNewWithLabel(nil, label1); err != nil {
+		return
+	}
 	group, err := opt1.GetGroup()
-	util.Check(err)
-	opt2, err = gtk.RadioButtonNewWithLabel(group, label2)
-	util.Check(err)
+	if err != nil {
+		return
+	}
+	if opt2, err = gtk.RadioButtonNewWithLabel(group, label2); err != nil {
+		return
+	}
 	//radiobutton.SetMode(false);
 	opt1.SetActive(true)
 	return
 }
 
 // Adds a new spin button widget.
-func SpinButton(min, max, step float64) *gtk.SpinButton {
-	spinButton, err := gtk.SpinButtonNewWithRange(min, max, step)
-	util.Check(err)
-	return spinButton
+func SpinButton(min, max, step float64) (*gtk.SpinButton, error) {
+	return gtk.SpinButtonNewWithRange(min, max, step)
 }
 
 // Adds a new textview widget.
-func TxtView() *gtk.TextView {
+func TxtView() (*gtk.TextView, error) {
 	tview, err := gtk.TextViewNew()
-	util.Check(err)
+	if err != nil {
+		return nil, err
+	}
 	//tp := gtk.TextWindowType(gtk.TEXT_WINDOW_WIDGET)
 	//txtbox.SetBorderWindowSize(tp, 2)
 	tview.SetEditable(false)
 	tview.SetCursorVisible(false)
-	return tview
+	return tview, nil
 }
 
-func GetBuffer(tview *gtk.TextView) *gtk.TextBuffer {
-	buffer, err := tview.GetBuffer()
-	if err != nil {
-		util.Fatal("Unable to get buffer:", err)
-	}
+// Returns the text buffer of a textview.
+func GetBuffer(tview *gtk.TextView) (*gtk.TextBuffer, error) {
+	return tview.GetBuffer()
+}
 
-	return buffer
+// Appends text to the end of a text buffer.
+func AppendText(buffer *gtk.TextBuffer, text string) {
+	buffer.Insert(buffer.GetEndIter(), text)
 }
 
 // Adds a new flowbox widget.
-func NewFlowBox(width, height int) FlowBox {
+func NewFlowBox(width, height int) (FlowBox, error) {
 	fbox, err := gtk.FlowBoxNew()
-	util.Check(err)
+	if err != nil {
+		return nil, err
+	}
 	fbox.SetVAlign(gtk.ALIGN_START)
 	fbox.SetMaxChildrenPerLine(2)
 	fbox.SetColumnSpacing(20)
 	fbox.SetRowSpacing(20)
 	fbox.SetSelectionMode(gtk.SELECTION_SINGLE)
 	fbox.SetSizeRequest(width, height)
-	return fbox
+	return fbox, nil
 }
 
 // Adds a new toolbar widget.
-func HeaderBar() (header *gtk.HeaderBar, importBtn, exportBtn, reloadBtn, quitBtn *gtk.Button) {
-	header, err := gtk.HeaderBarNew()
-	util.Check(err)
+func HeaderBar() (header *gtk.HeaderBar, devices Combo, importBtn, exportBtn, backupBtn, restoreBtn, reloadBtn, quitBtn *gtk.Button, err error) {
+	if header, err = gtk.HeaderBarNew(); err != nil {
+		return
+	}
 	header.SetShowCloseButton(false)
 	header.SetTitle("Microconfig v1.0")
 
-	quitBtn = NewButton("system-shutdown-symbolic")
-	quitBtn.SetLabel("Quit")
+	if quitBtn, err = labelButton("system-shutdown-symbolic", "Quit"); err != nil {
+		return
+	}
 	header.PackEnd(quitBtn)
-	reloadBtn = NewButton("view-refresh-symbolic")
-	reloadBtn.SetLabel("Reload")
+	if reloadBtn, err = labelButton("view-refresh-symbolic", "Reload"); err != nil {
+		return
+	}
 	header.PackEnd(reloadBtn)
 
 	hbox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
-	util.Check(err)
+	if err != nil {
+		return
+	}
 	hbox.SetSpacing(20)
 
-	importBtn = NewButton("view-sort-ascending-symbolic")
-	importBtn.SetLabel("Import")
-	exportBtn = NewButton("view-sort-descending-symbolic")
-	exportBtn.SetLabel("Export")
+	if importBtn, err = labelButton("view-sort-ascending-symbolic", "Import"); err != nil {
+		return
+	}
+	if exportBtn, err = labelButton("view-sort-descending-symbolic", "Export"); err != nil {
+		return
+	}
+	if backupBtn, err = labelButton("document-save-symbolic", "Backup"); err != nil {
+		return
+	}
+	if restoreBtn, err = labelButton("edit-undo-symbolic", "Restore"); err != nil {
+		return
+	}
+
+	if devices, err = ComboBox(); err != nil {
+		return
+	}
+	devices.SetTooltipText("Connected devices")
 
 	hbox.Add(importBtn)
 	hbox.Add(exportBtn)
+	hbox.Add(backupBtn)
+	hbox.Add(restoreBtn)
+	hbox.Add(devices)
 	header.PackStart(hbox)
 	return
 }
 
-// Adds a configuration panel widget for device settings.
-func ConfigPanel() (grid Grid, vendID, prodID Input, baudrate Combo, ioconf, outdef Input, leds, pins, usbcfg, suspend, upol Toggle, opt1, opt2 RadioButton, duration Spin, config, reset Button) {
-	var err error
-	grid, err = gtk.GridNew()
-	util.Check(err)
+// Adds a grid widget laid out as a panel.
+func panelGrid() (Grid, error) {
+	grid, err := gtk.GridNew()
+	if err != nil {
+		return nil, err
+	}
 	grid.SetOrientation(gtk.ORIENTATION_VERTICAL)
 	grid.SetMarginStart(20)
 	grid.SetMarginTop(20)
 	grid.SetMarginBottom(20)
 	grid.SetColumnSpacing(20)
 	grid.SetRowSpacing(20)
+	return grid, nil
+}
 
-	vendlab := Label("Vendor ID:")
-	vendID = InputBox()
-	vendID.SetMaxLength(8)
-	vendID.SetWidthChars(8)
-
-	prodlab := Label("Product ID:")
-	prodID = InputBox()
-	prodID.SetMaxLength(8)
-	prodID.SetWidthChars(8)
-
-	baudrates := [12]string{
-		"300", "1200", "2400", "4800", "9600", "19200",
-		"38400", "57600", "115200", "230400", "460800", "921600",
+// Attaches a new label to a panel grid.
+func attachLabel(grid Grid, text string, col, row int) error {
+	label, err := Label(text)
+	if err != nil {
+		return err
 	}
+	grid.Attach(label, col, row, 1, 1)
+	return nil
+}
 
-	baudlab := Label("Baud Rate:")
-	baudrate = ComboBox()
-
-	for _, baud := range baudrates {
-		baudrate.AppendText(baud)
+// Attaches a labeled widget as a row of a panel grid.
+func attachRow(grid Grid, row int, text string, widget gtk.IWidget) error {
+	if err := attachLabel(grid, text, 0, row); err != nil {
+		return err
 	}
+	grid.Attach(widget, 1, row, 1, 1)
+	return nil
+}
 
-	//baudrate.SetActive(1)
-
-	iolab := Label("IO Config:")
-	ioconf = InputBox()
-	ioconf.SetMaxLength(8)
-	ioconf.SetWidthChars(8)
-	ioconf.SetText("00000000")
-
-	outlab := Label("Output Default:")
-	outdef = InputBox()
-	outdef.SetMaxLength(8)
-	outdef.SetWidthChars(8)
-	outdef.SetText("00000000")
-
-	ledslab := Label("Enable Tx/Rx LEDs:")
-	leds = NewToggle()
-	leds.SetActive(false)
-
-	pinslab := Label("Enable CTS/RTS Pins:")
-	pins = NewToggle()
-	pins.SetActive(false)
+// Adds a configuration panel widget for device settings.
+func ConfigPanel() (grid Grid, vendID, prodID Input, baudrate Combo, baudinfo TextLabel, ioconf, outdef Input, leds, pins, usbcfg, suspend, upol Toggle, opt1, opt2 RadioButton, duration Spin, config, reset Button, err error) {
+	if grid, err = panelGrid(); err != nil {
+		return
+	}
 
-	usbcfglab := Label("Enable USBCFG Pin:")
-	usbcfg = NewToggle()
-	usbcfg.SetActive(false)
+	if vendID, err = newEntry(8, 8, ""); err != nil {
+		return
+	}
+	if prodID, err = newEntry(8, 8, ""); err != nil {
+		return
+	}
 
-	susplab := Label("Enable Suspend Pin:")
-	suspend = NewToggle()
-	suspend.SetActive(false)
+	if baudrate, err = ComboBoxEntry(); err != nil {
+		return
+	}
+	if baudinfo, err = Label(""); err != nil {
+		return
+	}
 
-	upollab := Label("Enable UART Polarity:")
-	upol = NewToggle()
-	upol.SetActive(false)
+	if ioconf, err = newEntry(8, 8, "00000000"); err != nil {
+		return
+	}
+	if outdef, err = newEntry(8, 8, "00000000"); err != nil {
+		return
+	}
 
-	config = NewButton("preferences-system-symbolic")
-	config.SetLabel("Configure")
+	for _, toggle := range []*Toggle{&leds, &pins, &usbcfg, &suspend, &upol} {
+		if *toggle, err = NewToggle(); err != nil {
+			return
+		}
+		(*toggle).SetActive(false)
+	}
 
-	reset = NewButton("document-revert-symbolic")
-	reset.SetLabel("Reset")
+	if config, err = labelButton("preferences-system-symbolic", "Configure"); err != nil {
+		return
+	}
+	if reset, err = labelButton("document-revert-symbolic", "Reset"); err != nil {
+		return
+	}
 
-	funclab := Label("LED Function:")
-	opt1, opt2 = RadioButtons("Blink LEDs", "Toggle LEDs")
+	if opt1, opt2, err = RadioButtons("Blink LEDs", "Toggle LEDs"); err != nil {
+		return
+	}
 
-	blinklab := Label("Blink Duration:")
-	duration = SpinButton(100, 200, 100)
+	if duration, err = SpinButton(100, 200, 100); err != nil {
+		return
+	}
 	duration.SetValue(100.0)
 
 	//save := NewButton("gtk-apply")
 	//save.SetLabel("Kaydet")
 
-	grid.Attach(vendlab, 0, 0, 1, 1)
-	grid.Attach(vendID, 1, 0, 1, 1)
-	grid.Attach(prodlab, 0, 1, 1, 1)
-	grid.Attach(prodID, 1, 1, 1, 1)
-	grid.Attach(baudlab, 0, 2, 1, 1)
-	grid.Attach(baudrate, 1, 2, 1, 1)
-	grid.Attach(iolab, 0, 3, 1, 1)
-	grid.Attach(ioconf, 1, 3, 1, 1)
-	grid.Attach(outlab, 0, 4, 1, 1)
-	grid.Attach(outdef, 1, 4, 1, 1)
-	grid.Attach(ledslab, 0, 5, 1, 1)
-	grid.Attach(leds, 1, 5, 1, 1)
-	grid.Attach(pinslab, 0, 6, 1, 1)
-	grid.Attach(pins, 1, 6, 1, 1)
-	grid.Attach(usbcfglab, 0, 7, 1, 1)
-	grid.Attach(usbcfg, 1, 7, 1, 1)
-	grid.Attach(susplab, 0, 8, 1, 1)
-	grid.Attach(suspend, 1, 8, 1, 1)
-	grid.Attach(upollab, 0, 9, 1, 1)
-	grid.Attach(upol, 1, 9, 1, 1)
-	grid.Attach(funclab, 0, 10, 1, 1)
-	grid.Attach(opt1, 1, 10, 1, 1)
+	rows := []struct {
+		label  string
+		widget gtk.IWidget
+	}{
+		{"Vendor ID:", vendID},
+		{"Product ID:", prodID},
+		{"Baud Rate:", baudrate},
+		{"IO Config:", ioconf},
+		{"Output Default:", outdef},
+		{"Enable Tx/Rx LEDs:", leds},
+		{"Enable CTS/RTS Pins:", pins},
+		{"Enable USBCFG Pin:", usbcfg},
+		{"Enable Suspend Pin:", suspend},
+		{"Enable UART Polarity:", upol},
+		{"LED Function:", opt1},
+	}
+	for row, r := range rows {
+		if err = attachRow(grid, row, r.label, r.widget); err != nil {
+			return
+		}
+	}
+	grid.Attach(baudinfo, 2, 2, 1, 1)
 	grid.Attach(opt2, 1, 11, 1, 1)
-	grid.Attach(blinklab, 0, 12, 1, 1)
-	grid.Attach(duration, 1, 12, 1, 1)
+
+	if err = attachRow(grid, 12, "Blink Duration:", duration); err != nil {
+		return
+	}
 	grid.Attach(config, 0, 14, 1, 1)
 	grid.Attach(reset, 1, 14, 1, 1)
 
@@ -278,90 +344,278 @@ func ConfigPanel() (grid Grid, vendID, prodID Input, baudrate Combo, ioconf, out
 }
 
 // Adds a new panel widget.
-func InfoPanel(manufacturer, product, serial string) (grid Grid, statico *gtk.Image, manufact, prod, serinum Input, infoico Button, console TextView) {
-	var err error
-	grid, err = gtk.GridNew()
-	util.Check(err)
-	grid.SetOrientation(gtk.ORIENTATION_VERTICAL)
-	grid.SetMarginStart(20)
-	grid.SetMarginTop(20)
-	grid.SetMarginBottom(20)
-	grid.SetColumnSpacing(20)
-	grid.SetRowSpacing(20)
-
-	statlab := Label("Connection Status:")
-	statico = NewIcon("object-select-symbolic")
-
-	manulab := Label("Manufacturer:")
-	manufact = InputBox()
-	manufact.SetMaxLength(50)
-	manufact.SetWidthChars(50)
-	manufact.SetText(manufacturer)
+func InfoPanel(manufacturer, product, serial string) (grid Grid, statico *gtk.Image, manufact, prod, serinum Input, infoico Button, console TextView, err error) {
+	if grid, err = panelGrid(); err != nil {
+		return
+	}
 
-	prodlab := Label("Product:")
-	prod = InputBox()
-	prod.SetMaxLength(50)
-	prod.SetWidthChars(50)
-	prod.SetText(product)
+	if statico, err = NewIcon("object-select-symbolic"); err != nil {
+		return
+	}
 
-	serilab := Label("Serial Number:")
-	serinum = InputBox()
-	serinum.SetMaxLength(50)
-	serinum.SetWidthChars(50)
-	serinum.SetText(serial)
+	if manufact, err = newEntry(63, 50, manufacturer); err != nil {
+		return
+	}
+	if prod, err = newEntry(63, 50, product); err != nil {
+		return
+	}
+	if serinum, err = newEntry(50, 50, serial); err != nil {
+		return
+	}
+	serinum.SetEditable(false)
 
-	infoico = NewButton("dialog-information-symbolic")
-	infoico.SetLabel("Info Console")
+	if infoico, err = labelButton("dialog-information-symbolic", "Info Console"); err != nil {
+		return
+	}
 
 	scroll, err := gtk.ScrolledWindowNew(nil, nil)
-	util.Check(err)
+	if err != nil {
+		return
+	}
 	scroll.SetPolicy(gtk.POLICY_NEVER, gtk.POLICY_AUTOMATIC)
-	console = TxtView()
+	if console, err = TxtView(); err != nil {
+		return
+	}
 	scroll.Add(console)
 
 	//connlab = Label("")
 	//connlab.SetUseMarkup(true)
 	//connlab.SetMarkup("<b>Connected</b>")
 
-	grid.Attach(statlab, 0, 0, 1, 1)
-	grid.Attach(statico, 1, 0, 1, 1)
-	grid.Attach(manulab, 0, 1, 1, 1)
-	grid.Attach(manufact, 1, 1, 1, 1)
-	grid.Attach(prodlab, 0, 2, 1, 1)
-	grid.Attach(prod, 1, 2, 1, 1)
-	grid.Attach(serilab, 0, 3, 1, 1)
-	grid.Attach(serinum, 1, 3, 1, 1)
+	rows := []struct {
+		label  string
+		widget gtk.IWidget
+	}{
+		{"Connection Status:", statico},
+		{"Manufacturer:", manufact},
+		{"Product:", prod},
+		{"Serial Number:", serinum},
+	}
+	for row, r := range rows {
+		if err = attachRow(grid, row, r.label, r.widget); err != nil {
+			return
+		}
+	}
 	grid.Attach(infoico, 0, 4, 1, 1)
 	grid.Attach(scroll, 0, 5, 2, 15)
 
 	return
 }
 
+// Adds a GPIO panel widget showing GP0-GP7 pins.
+func GPIOPanel() (grid Grid, dirs [8]TextLabel, levels [8]Icon, outs [8]Toggle, err error) {
+	if grid, err = panelGrid(); err != nil {
+		return
+	}
+
+	for col, text := range []string{"Pin", "Direction", "Level", "Output"} {
+		if err = attachLabel(grid, text, col, 0); err != nil {
+			return
+		}
+	}
+
+	for pin := 0; pin < 8; pin++ {
+		if dirs[pin], err = Label("Input"); err != nil {
+			return
+		}
+		if levels[pin], err = NewIcon("radio-symbolic"); err != nil {
+			return
+		}
+		if outs[pin], err = NewToggle(); err != nil {
+			return
+		}
+		outs[pin].SetSensitive(false)
+
+		if err = attachLabel(grid, fmt.Sprintf("GP%d:", pin), 0, pin+1); err != nil {
+			return
+		}
+		grid.Attach(dirs[pin], 1, pin+1, 1, 1)
+		grid.Attach(levels[pin], 2, pin+1, 1, 1)
+		grid.Attach(outs[pin], 3, pin+1, 1, 1)
+	}
+
+	return
+}
+
+// Shows the logic level of a pin on its level indicator.
+func SetLevel(level Icon, high bool) {
+	if high {
+		level.SetFromIconName("radio-checked-symbolic", gtk.ICON_SIZE_LARGE_TOOLBAR)
+	} else {
+		level.SetFromIconName("radio-symbolic", gtk.ICON_SIZE_LARGE_TOOLBAR)
+	}
+}
+
+// Shows the device connection state on the status icon.
+func SetStatus(stat Icon, online bool) {
+	if online {
+		stat.SetFromIconName("object-select-symbolic", gtk.ICON_SIZE_LARGE_TOOLBAR)
+	} else {
+		stat.SetFromIconName("network-offline-symbolic", gtk.ICON_SIZE_LARGE_TOOLBAR)
+	}
+}
+
+// Calls f every interval milliseconds until it returns false.
+func Poll(interval uint, f func() bool) {
+	glib.TimeoutAdd(interval, f)
+}
+
+// Runs f on the GTK main loop. Safe to call from other goroutines.
+func Idle(f func()) {
+	glib.IdleAdd(f)
+}
+
 // Adds root box containing other GTK widgets.
-func RootBox(confPanel, infoPanel Grid) *gtk.Box {
+func RootBox(confPanel, gpioPanel, infoPanel Grid) (*gtk.Box, error) {
 	rootBox, err := gtk.BoxNew(gtk.ORIENTATION_HORIZONTAL, 0)
-	util.Check(err)
+	if err != nil {
+		return nil, err
+	}
 	rootBox.SetSpacing(0)
 	vsep, err := gtk.SeparatorNew(gtk.ORIENTATION_VERTICAL)
-	util.Check(err)
+	if err != nil {
+		return nil, err
+	}
+	gsep, err := gtk.SeparatorNew(gtk.ORIENTATION_VERTICAL)
+	if err != nil {
+		return nil, err
+	}
 	rootBox.PackStart(confPanel, true, true, 0)
 	rootBox.PackStart(vsep, false, false, 0)
+	rootBox.PackStart(gpioPanel, true, true, 0)
+	rootBox.PackStart(gsep, false, false, 0)
 	rootBox.PackEnd(infoPanel, true, true, 0)
-	return rootBox
+	return rootBox, nil
 }
 
 // Renders the window with the container widgets.
 func Render(win *gtk.Window, header Header, rootBox *gtk.Box) {
 	win.Add(rootBox)
 	win.SetTitlebar(header)
-	win.SetDefaultSize(800, 600)
+	win.SetDefaultSize(1100, 600)
 	//win.Maximize()
 	win.ShowAll()
 	gtk.Main()
 }
 
-func ChooseXML(win *gtk.Window) string {
-	title := "Import From XML"
-	xml := gtk.OpenFileChooserNative(title, win)
-	return *xml
+// File filters for the supported profile formats.
+var profileTypes = [][]string{
+	{"All Profiles", "*.xml", "*.json", "*.yaml", "*.yml", "*.toml"},
+	{"XML", "*.xml"},
+	{"JSON", "*.json"},
+	{"YAML", "*.yaml", "*.yml"},
+	{"TOML", "*.toml"},
+}
+
+// File filters for backup archives.
+var backupTypes = [][]string{
+	{"Backup Archives", "*.json"},
+}
+
+// Adds file filters given as name followed by patterns.
+func addFilters(dialog *gtk.FileChooserNativeDialog, filters [][]string) error {
+	for _, patterns := range filters {
+		filter, err := gtk.FileFilterNew()
+		if err != nil {
+			return err
+		}
+		filter.SetName(patterns[0])
+		for _, pattern := range patterns[1:] {
+			filter.AddPattern(pattern)
+		}
+		dialog.AddFilter(filter)
+	}
+
+	return nil
+}
+
+// Shows a file chooser for opening files of given types.
+// Returns an empty string if the dialog is cancelled.
+func chooseFile(win *gtk.Window, title string, filters [][]string) (string, error) {
+	dialog, err := gtk.FileChooserNativeDialogNew(title, win, gtk.FILE_CHOOSER_ACTION_OPEN, "_Open", "_Cancel")
+	if err != nil {
+		return "", err
+	}
+	defer dialog.Destroy()
+
+	if err := addFilters(dialog, filters); err != nil {
+		return "", err
+	}
+
+	if dialog.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return "", nil
+	}
+	return dialog.GetFilename(), nil
+}
+
+// Shows a file chooser for saving files of given types.
+// Returns an empty string if the dialog is cancelled.
+func saveFile(win *gtk.Window, title, name string, filters [][]string) (string, error) {
+	dialog, err := gtk.FileChooserNativeDialogNew(title, win, gtk.FILE_CHOOSER_ACTION_SAVE, "_Save", "_Cancel")
+	if err != nil {
+		return "", err
+	}
+	defer dialog.Destroy()
+
+	if err := addFilters(dialog, filters); err != nil {
+		return "", err
+	}
+	dialog.SetCurrentName(name)
+	dialog.SetDoOverwriteConfirmation(true)
+
+	if dialog.Run() != int(gtk.RESPONSE_ACCEPT) {
+		return "", nil
+	}
+	return dialog.GetFilename(), nil
+}
+
+// Shows a file chooser for opening configuration profiles.
+// Returns an empty string if the dialog is cancelled.
+func ChooseProfile(win *gtk.Window) (string, error) {
+	return chooseFile(win, "Import Profile", profileTypes)
+}
+
+// Shows a file chooser for saving configuration profiles.
+// The file extension selects the format. Returns an empty string if the
+// dialog is cancelled.
+func SaveProfile(win *gtk.Window) (string, error) {
+	return saveFile(win, "Export Profile", "microconfig.xml", profileTypes)
+}
+
+// Shows a file chooser for opening backup archives.
+// Returns an empty string if the dialog is cancelled.
+func ChooseBackup(win *gtk.Window) (string, error) {
+	return chooseFile(win, "Restore Backup", backupTypes)
+}
+
+// Shows a file chooser for saving a backup archive under the suggested
+// name. Returns an empty string if the dialog is cancelled.
+func SaveBackup(win *gtk.Window, name string) (string, error) {
+	return saveFile(win, "Backup Device", name, backupTypes)
+}
+
+// Shows a modal error message dialog.
+func ErrorDialog(win *gtk.Window, title, msg string) {
+	dialog := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_ERROR, gtk.BUTTONS_OK, "%s", title)
+	dialog.FormatSecondaryText("%s", msg)
+	dialog.Run()
+	dialog.Destroy()
+}
+
+// Shows a warning dialog and reports whether the user confirmed it.
+func ConfirmDialog(win *gtk.Window, title, msg string) bool {
+	dialog := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_WARNING, gtk.BUTTONS_YES_NO, "%s", title)
+	dialog.FormatSecondaryText("%s", msg)
+	response := dialog.Run()
+	dialog.Destroy()
+	return response == gtk.RESPONSE_YES
+}
+
+// Shows pending changes and reports whether the user accepted them.
+func ReviewDialog(win *gtk.Window, title, changes string) bool {
+	dialog := gtk.MessageDialogNew(win, gtk.DIALOG_MODAL, gtk.MESSAGE_QUESTION, gtk.BUTTONS_OK_CANCEL, "%s", title)
+	dialog.FormatSecondaryText("%s", changes)
+	response := dialog.Run()
+	dialog.Destroy()
+	return response == gtk.RESPONSE_OK
 }
@@ -0,0 +1,109 @@
+// Reprogramming of USB descriptors with BASE_CONFIGURE command.
+// New descriptors take effect after the device re-enumerates.
+
+package usb
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Sends the BASE_CONFIGURE command writing new Vendor/Product IDs.
+// The device enumerates with the new IDs after the next reset and can
+// no longer be found with the old ones.
+func (micro *MCP) SetVIDPIDCmd(VendID, ProdID ID) (int, error) {
+	buf := make([]byte, ReportSize)
+	buf[0] = BASE_CONFIGURE
+	buf[1] = BASE_VID_PID
+	buf[2] = BASE_KEY_H
+	buf[3] = BASE_KEY_L
+	buf[4] = uint8(VendID)
+	buf[5] = uint8(VendID >> 8)
+	buf[6] = uint8(ProdID)
+	buf[7] = uint8(ProdID >> 8)
+
+	// write BASE_CONFIGURE command opcode via transport.
+	val, err := micro.Transport.Write(buf)
+
+	if err != nil {
+		return val, wrapErr("write", err)
+	}
+
+	return val, nil
+}
+
+// Checks that a string fits into a string descriptor: 1 to MaxStrLen
+// printable characters from the Unicode Basic Multilingual Plane.
+func ValidateString(str string) error {
+	if !utf8.ValidString(str) {
+		return fmt.Errorf("string descriptor %q is not valid UTF-8", str)
+	}
+
+	n := utf8.RuneCountInString(str)
+	if n == 0 {
+		return fmt.Errorf("string descriptor is empty")
+	}
+
+	if n > MaxStrLen {
+		return fmt.Errorf("string descriptor %q exceeds %d characters", str, MaxStrLen)
+	}
+
+	for _, r := range str {
+		if r > 0xFFFF || !unicode.IsPrint(r) {
+			return fmt.Errorf("string descriptor %q contains unsupported character %q", str, r)
+		}
+	}
+
+	return nil
+}
+
+// Sends the BASE_CONFIGURE commands writing a string descriptor.
+// The string is sent as UTF-16 in chunks of StrChunkSize characters.
+func (micro *MCP) SetStringCmd(desc uint8, str string) error {
+	if err := ValidateString(str); err != nil {
+		return err
+	}
+
+	chars := utf16.Encode([]rune(str))
+	for index := 0; index*StrChunkSize < len(chars); index++ {
+		buf := make([]byte, ReportSize)
+		buf[0] = BASE_CONFIGURE
+		buf[1] = desc
+		buf[2] = uint8(index)
+		buf[3] = uint8(len(chars))
+
+		for i := 0; i < StrChunkSize; i++ {
+			pos := index*StrChunkSize + i
+			if pos >= len(chars) {
+				break
+			}
+			buf[4+2*i] = uint8(chars[pos])
+			buf[4+2*i+1] = uint8(chars[pos] >> 8)
+		}
+
+		// write BASE_CONFIGURE command opcode via transport.
+		if _, err := micro.Transport.Write(buf); err != nil {
+			return wrapErr("write", err)
+		}
+	}
+
+	return nil
+}
+
+// Writes device manufacturer information and verifies that the
+// configuration was left untouched.
+func (micro *MCP) WriteManufacturer(manufacturer string) error {
+	return micro.verified(func() error {
+		return micro.SetStringCmd(BASE_MANUFACTURER, manufacturer)
+	})
+}
+
+// Writes device's product name and verifies that the configuration
+// was left untouched.
+func (micro *MCP) WriteProduct(product string) error {
+	return micro.verified(func() error {
+		return micro.SetStringCmd(BASE_PRODUCT, product)
+	})
+}
@@ -0,0 +1,97 @@
+// Baud rate generator of the MCP2200 UART.
+
+package usb
+
+import (
+	"fmt"
+	"math"
+)
+
+// Frequency of the clock divided by the baud rate generator.
+const BaudClock = 12000000
+
+// define supported baud rate range
+const (
+	MinBaudRate = 300
+	MaxBaudRate = 1000000
+)
+
+// Largest accepted deviation of the actual from the requested rate in percent.
+const MaxBaudError = 2.0
+
+// Standard baud rates.
+var BaudRates = []int{
+	300, 1200, 2400, 4800, 9600, 19200,
+	38400, 57600, 115200, 230400, 460800, 921600,
+}
+
+// Baud represents a baud rate setting. The UART runs at
+// BaudClock / (Divisor + 1), which is only close to Rate for most rates.
+type Baud struct {
+	// Requested baud rate.
+	Rate int
+
+	// Value of the Baud_Rate_H/Baud_Rate_L registers.
+	Divisor uint16
+}
+
+// Computes the divisor closest to the requested rate. Rates outside
+// MinBaudRate..MaxBaudRate and rates that can only be reached with more
+// than MaxBaudError percent deviation are rejected.
+func NewBaud(rate int) (Baud, error) {
+	if rate < MinBaudRate || rate > MaxBaudRate {
+		return Baud{}, fmt.Errorf("baud rate %d out of range %d-%d", rate, MinBaudRate, MaxBaudRate)
+	}
+
+	divisor := math.Round(BaudClock/float64(rate)) - 1
+	baud := Baud{rate, uint16(math.Max(0, math.Min(divisor, math.MaxUint16)))}
+
+	if dev := baud.Deviation(); math.Abs(dev) > MaxBaudError {
+		return Baud{}, fmt.Errorf("baud rate %d not reachable: closest is %.0f (%+.2f%%)", rate, baud.Actual(), dev)
+	}
+
+	return baud, nil
+}
+
+// Decodes the Baud_Rate_H/Baud_Rate_L registers. Rate is set to the
+// standard rate using the same divisor, or to the rounded actual rate.
+// Divisors NewBaud never produces may give rates outside
+// MinBaudRate..MaxBaudRate, which InRange reports.
+func BaudFromBytes(high, low uint8) Baud {
+	baud := Baud{Divisor: uint16(high)<<8 | uint16(low)}
+
+	for _, rate := range BaudRates {
+		if std, err := NewBaud(rate); err == nil && std.Divisor == baud.Divisor {
+			baud.Rate = rate
+			return baud
+		}
+	}
+
+	baud.Rate = int(math.Round(baud.Actual()))
+	return baud
+}
+
+// Reports whether Rate lies within MinBaudRate..MaxBaudRate.
+func (baud Baud) InRange() bool {
+	return baud.Rate >= MinBaudRate && baud.Rate <= MaxBaudRate
+}
+
+// Returns the rate the UART actually runs at.
+func (baud Baud) Actual() float64 {
+	return BaudClock / float64(int(baud.Divisor)+1)
+}
+
+// Returns the deviation of the actual from the requested rate in percent.
+func (baud Baud) Deviation() float64 {
+	return (baud.Actual() - float64(baud.Rate)) / float64(baud.Rate) * 100
+}
+
+// Returns the Baud_Rate_H/Baud_Rate_L register values.
+func (baud Baud) Bytes() (high, low uint8) {
+	return uint8(baud.Divisor >> 8), uint8(baud.Divisor)
+}
+
+// Formats the actual rate and deviation, e.g. "115384.6 baud (+0.16%)".
+func (baud Baud) String() string {
+	return fmt.Sprintf("%.1f baud (%+.2f%%)", baud.Actual(), baud.Deviation())
+}
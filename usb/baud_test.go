@@ -0,0 +1,82 @@
+package usb
+
+import "testing"
+
+func TestNewBaud(t *testing.T) {
+	tests := []struct {
+		rate    int
+		divisor uint16
+	}{
+		{MinBaudRate, 39999},
+		{9600, 1249},
+		{115200, 103},
+		{250000, 47},
+		{921600, 12},
+		{MaxBaudRate, 11},
+	}
+
+	for _, tc := range tests {
+		baud, err := NewBaud(tc.rate)
+		if err != nil {
+			t.Errorf("NewBaud(%d): %v", tc.rate, err)
+			continue
+		}
+		if baud.Rate != tc.rate || baud.Divisor != tc.divisor {
+			t.Errorf("NewBaud(%d) = %+v, want divisor %d", tc.rate, baud, tc.divisor)
+		}
+		if dev := baud.Deviation(); dev > MaxBaudError || dev < -MaxBaudError {
+			t.Errorf("NewBaud(%d) deviation = %.2f%%", tc.rate, dev)
+		}
+	}
+}
+
+func TestNewBaudRejects(t *testing.T) {
+	// 900000 falls between divisors 12 and 13, both more than 2% off
+	for _, rate := range []int{0, MinBaudRate - 1, MaxBaudRate + 1, 900000} {
+		if baud, err := NewBaud(rate); err == nil {
+			t.Errorf("NewBaud(%d) = %+v, want error", rate, baud)
+		}
+	}
+}
+
+func TestBaudFromBytes(t *testing.T) {
+	tests := []struct {
+		high, low uint8
+		rate      int
+		inRange   bool
+	}{
+		{0x04, 0xE1, 9600, true},
+		{0x00, 0x67, 115200, true},
+		{0x00, 0x2F, 250000, true},
+		{0x9C, 0x3F, MinBaudRate, true},
+		{0x00, 0x0B, MaxBaudRate, true},
+		{0x00, 0x00, BaudClock, false},
+		{0x00, 0x05, 2000000, false},
+		{0xFF, 0xFF, 183, false},
+	}
+
+	for _, tc := range tests {
+		baud := BaudFromBytes(tc.high, tc.low)
+		if baud.Rate != tc.rate {
+			t.Errorf("BaudFromBytes(0x%02X, 0x%02X).Rate = %d, want %d", tc.high, tc.low, baud.Rate, tc.rate)
+		}
+		if baud.InRange() != tc.inRange {
+			t.Errorf("BaudFromBytes(0x%02X, 0x%02X).InRange() = %v, want %v", tc.high, tc.low, baud.InRange(), tc.inRange)
+		}
+		if high, low := baud.Bytes(); high != tc.high || low != tc.low {
+			t.Errorf("BaudFromBytes(0x%02X, 0x%02X).Bytes() = 0x%02X, 0x%02X", tc.high, tc.low, high, low)
+		}
+	}
+}
+
+func TestBaudRoundTrip(t *testing.T) {
+	for _, rate := range BaudRates {
+		baud, err := NewBaud(rate)
+		if err != nil {
+			t.Fatalf("NewBaud(%d): %v", rate, err)
+		}
+		if got := BaudFromBytes(baud.Bytes()); got != baud {
+			t.Errorf("BaudFromBytes(NewBaud(%d).Bytes()) = %+v, want %+v", rate, got, baud)
+		}
+	}
+}
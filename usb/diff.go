@@ -0,0 +1,73 @@
+// Field by field comparison of device configurations.
+
+package usb
+
+import (
+	"fmt"
+)
+
+// Represents a configuration field that differs between two states.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Formats the change as "field: old -> new".
+func (change Change) String() string {
+	return fmt.Sprintf("%s: %s -> %s", change.Field, change.Old, change.New)
+}
+
+// Returns "on" for set flags, "off" otherwise.
+func onOff(flag bool) string {
+	if flag {
+		return "on"
+	}
+	return "off"
+}
+
+// Formats a bitmap as binary digits.
+func bits(bitmap uint8) string {
+	return fmt.Sprintf("%08b", bitmap)
+}
+
+// Returns the CONFIGURE fields that differ between two configurations.
+// Alt_Pins and Alt_Opts are compared bit by bit.
+func DiffConfig(old, new *Data) []Change {
+	var changes []Change
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, Change{field, o, n})
+		}
+	}
+
+	add("IO_Bmap", bits(old.IO_Bmap), bits(new.IO_Bmap))
+	add("IO_Default", bits(old.IO_Default), bits(new.IO_Default))
+
+	var oldPins, newPins AltPins
+	oldPins.UnmarshalByte(old.Alt_Pins)
+	newPins.UnmarshalByte(new.Alt_Pins)
+	add("Alt_Pins.SSPND", onOff(oldPins.SSPND), onOff(newPins.SSPND))
+	add("Alt_Pins.USBCFG", onOff(oldPins.USBCFG), onOff(newPins.USBCFG))
+	add("Alt_Pins.RxLED", onOff(oldPins.RxLED), onOff(newPins.RxLED))
+	add("Alt_Pins.TxLED", onOff(oldPins.TxLED), onOff(newPins.TxLED))
+	add("Alt_Pins.Reserved", bits(oldPins.Reserved), bits(newPins.Reserved))
+
+	var oldOpts, newOpts AltOpts
+	oldOpts.UnmarshalByte(old.Alt_Opts)
+	newOpts.UnmarshalByte(new.Alt_Opts)
+	add("Alt_Opts.RxTGL", onOff(oldOpts.RxTGL), onOff(newOpts.RxTGL))
+	add("Alt_Opts.TxTGL", onOff(oldOpts.TxTGL), onOff(newOpts.TxTGL))
+	add("Alt_Opts.LEDX", onOff(oldOpts.LEDX), onOff(newOpts.LEDX))
+	add("Alt_Opts.Invert", onOff(oldOpts.Invert), onOff(newOpts.Invert))
+	add("Alt_Opts.HW_Flow", onOff(oldOpts.HW_Flow), onOff(newOpts.HW_Flow))
+	add("Alt_Opts.Reserved", bits(oldOpts.Reserved), bits(newOpts.Reserved))
+
+	oldBaud := BaudFromBytes(old.Baud_Rate_H, old.Baud_Rate_L)
+	newBaud := BaudFromBytes(new.Baud_Rate_H, new.Baud_Rate_L)
+	if oldBaud.Divisor != newBaud.Divisor {
+		add("Baud_Rate", fmt.Sprint(oldBaud.Rate), fmt.Sprint(newBaud.Rate))
+	}
+
+	return changes
+}
@@ -0,0 +1,109 @@
+// Access to the 256-byte MCP2200 user EEPROM.
+
+package usb
+
+import (
+	"fmt"
+)
+
+// Checks that n bytes starting at addr are within the EEPROM.
+func checkEEPROMRange(addr, n int) error {
+	if addr < 0 || n < 0 || addr+n > EEPROMSize {
+		return fmt.Errorf("EEPROM range out of bounds: addr %d, length %d", addr, n)
+	}
+	return nil
+}
+
+// Reads a single byte from the EEPROM.
+func (micro *MCP) ReadEEPROM(addr int) (uint8, error) {
+	if err := checkEEPROMRange(addr, 1); err != nil {
+		return 0, err
+	}
+	return micro.ReadEEPROMCmd(uint8(addr))
+}
+
+// Writes a single byte to the EEPROM and verifies it by reading it back.
+// The write is repeated up to WriteRetries times.
+func (micro *MCP) WriteEEPROM(addr int, value uint8) error {
+	if err := checkEEPROMRange(addr, 1); err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if _, err := micro.WriteEEPROMCmd(uint8(addr), value); err != nil {
+			return err
+		}
+
+		got, err := micro.ReadEEPROMCmd(uint8(addr))
+		if err != nil {
+			return err
+		}
+
+		if got == value {
+			return nil
+		}
+
+		if attempt >= WriteRetries {
+			field := fmt.Sprintf("EEPROM[0x%02X]", addr)
+			return &Error{"verify", ErrProtocol, Mismatch{{field, fmt.Sprintf("0x%02X", value), fmt.Sprintf("0x%02X", got)}}}
+		}
+	}
+}
+
+// Reads n bytes from the EEPROM starting at addr.
+func (micro *MCP) ReadEEPROMRange(addr, n int) ([]byte, error) {
+	if err := checkEEPROMRange(addr, n); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, n)
+	for i := range data {
+		val, err := micro.ReadEEPROMCmd(uint8(addr + i))
+		if err != nil {
+			return nil, err
+		}
+		data[i] = val
+	}
+
+	return data, nil
+}
+
+// Writes data to the EEPROM starting at addr, verifying each written
+// byte by reading it back.
+// Bytes already holding the wanted value are skipped to save write cycles.
+func (micro *MCP) WriteEEPROMRange(addr int, data []byte) error {
+	if err := checkEEPROMRange(addr, len(data)); err != nil {
+		return err
+	}
+
+	for i, value := range data {
+		val, err := micro.ReadEEPROMCmd(uint8(addr + i))
+		if err != nil {
+			return err
+		}
+
+		if val == value {
+			continue
+		}
+
+		if err := micro.WriteEEPROM(addr+i, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reads the whole EEPROM.
+func (micro *MCP) DumpEEPROM() ([]byte, error) {
+	return micro.ReadEEPROMRange(0, EEPROMSize)
+}
+
+// Writes the whole EEPROM from a 256-byte image.
+func (micro *MCP) LoadEEPROM(image []byte) error {
+	if len(image) != EEPROMSize {
+		return fmt.Errorf("EEPROM image must be %d bytes, got %d", EEPROMSize, len(image))
+	}
+
+	return micro.WriteEEPROMRange(0, image)
+}
@@ -0,0 +1,403 @@
+// In-process MCP2200 emulator implementing the Transport interface.
+
+package usb
+
+import (
+	"errors"
+	"sync"
+	"unicode/utf16"
+)
+
+var (
+	errEmuClosed   = errors.New("emulator: device closed")
+	errEmuNoReport = errors.New("emulator: no pending input report")
+	errEmuReport   = errors.New("emulator: invalid report size")
+)
+
+// Represents the USB descriptor fields which are stored in NVRAM.
+type Descriptor struct {
+	VendID       ID
+	ProdID       ID
+	Manufacturer string
+	Product      string
+}
+
+// Emulator represents a software MCP2200 which answers the HID command set
+// and keeps its configuration, EEPROM and GPIO state between commands.
+type Emulator struct {
+	mu sync.Mutex
+
+	// USB descriptors reported by the device.
+	Desc Descriptor
+
+	// USB descriptors written by BASE_CONFIGURE, active after Reset.
+	NVDesc Descriptor
+
+	// Serial number string descriptor.
+	Serial string
+
+	// Device release number reported as bcdDevice.
+	Release uint16
+
+	// Configuration bytes as reported by READ_ALL.
+	Config Data
+
+	// User EEPROM contents.
+	EEPROM [EEPROMSize]byte
+
+	// Logic levels applied to the input pins from outside.
+	Inputs uint8
+
+	// Latched levels of the output pins.
+	outputs uint8
+
+	// Pending string descriptor chunks written by BASE_CONFIGURE.
+	strbuf map[uint8][]uint16
+
+	// Queued input reports.
+	pending [][]byte
+	closed  bool
+}
+
+// Creates a new emulator with MCP2200 factory defaults.
+func NewEmulator() *Emulator {
+	emu := new(Emulator)
+	emu.Desc = Descriptor{
+		VendID:       0x04D8,
+		ProdID:       0x00DF,
+		Manufacturer: "Microchip Technology Inc.",
+		Product:      "MCP2200 USB Serial Port Emulation",
+	}
+	emu.NVDesc = emu.Desc
+	emu.Serial = "0000000000"
+	emu.Release = 0x0100
+
+	// all pins are inputs, 9600 baud
+	emu.Config.IO_Bmap = 0xFF
+	emu.Config.Baud_Rate_H = 0x04
+	emu.Config.Baud_Rate_L = 0xE1
+
+	emu.strbuf = make(map[uint8][]uint16)
+	for i := range emu.EEPROM {
+		emu.EEPROM[i] = 0xFF
+	}
+
+	emu.powerOn()
+	return emu
+}
+
+// Emulator behind the emulator backend, created on first use.
+var (
+	sharedMu  sync.Mutex
+	sharedEmu *Emulator
+)
+
+// Returns the emulator opened and listed by the emulator backend. It is
+// shared by all handles so that its configuration, EEPROM, strings and
+// USB IDs survive closing and reopening the device.
+func sharedEmulator() *Emulator {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if sharedEmu == nil {
+		sharedEmu = NewEmulator()
+	}
+	return sharedEmu
+}
+
+// Returns the device info of the emulator.
+func (emu *Emulator) Info() DeviceInfo {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return DeviceInfo{
+		Backend: BackendEmulator,
+		Path:    "emulator",
+		Serial:  emu.Serial,
+		Product: emu.Desc.Product,
+		Release: bcdRelease(emu.Release),
+		VendID:  emu.Desc.VendID,
+		ProdID:  emu.Desc.ProdID,
+	}
+}
+
+// Reports whether the emulator enumerates with given VID/PID.
+func (emu *Emulator) matches(VendID, ProdID ID) bool {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return emu.Desc.VendID == VendID && emu.Desc.ProdID == ProdID
+}
+
+// Loads output latches from the IO_Default configuration.
+func (emu *Emulator) powerOn() {
+	emu.outputs = emu.Config.IO_Default
+	emu.updatePort()
+}
+
+// Recomputes IO_Port_Val from the output latches and input levels.
+func (emu *Emulator) updatePort() {
+	dir := emu.Config.IO_Bmap
+	emu.Config.IO_Port_Val = (emu.outputs &^ dir) | (emu.Inputs & dir)
+}
+
+// Sets the logic levels applied to the input pins.
+func (emu *Emulator) SetInputs(levels uint8) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	emu.Inputs = levels
+	emu.updatePort()
+}
+
+// Returns the current GPIO port value.
+func (emu *Emulator) PortValue() uint8 {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return emu.Config.IO_Port_Val
+}
+
+// Builds an input report from the current state for given opcode.
+func (emu *Emulator) newReport(opcode uint8) []byte {
+	buf := make([]byte, ReportSize)
+	buf[0] = opcode
+	buf[1] = emu.Config.EEP_Addr
+	buf[2] = emu.Config.Reserved1
+	buf[3] = emu.Config.EEP_Val
+	buf[4] = emu.Config.IO_Bmap
+	buf[5] = emu.Config.Alt_Pins
+	buf[6] = emu.Config.IO_Default
+	buf[7] = emu.Config.Alt_Opts
+	buf[8] = emu.Config.Baud_Rate_H
+	buf[9] = emu.Config.Baud_Rate_L
+	buf[10] = emu.Config.IO_Port_Val
+	return buf
+}
+
+// Handles BASE_CONFIGURE sub-commands.
+func (emu *Emulator) baseConfig(report []byte) {
+	switch report[1] {
+	case BASE_VID_PID:
+		if report[2] != BASE_KEY_H || report[3] != BASE_KEY_L {
+			return
+		}
+		emu.NVDesc.VendID = ID(uint16(report[5])<<8 | uint16(report[4]))
+		emu.NVDesc.ProdID = ID(uint16(report[7])<<8 | uint16(report[6]))
+	case BASE_MANUFACTURER, BASE_PRODUCT:
+		index, length := int(report[2]), int(report[3])
+		if length > MaxStrLen || (index > 0 && index*StrChunkSize >= length) {
+			return
+		}
+
+		chars := emu.strbuf[report[1]]
+		if index == 0 {
+			chars = make([]uint16, length)
+		} else if len(chars) != length {
+			return
+		}
+
+		for i := 0; i < StrChunkSize; i++ {
+			pos := index*StrChunkSize + i
+			if pos >= length {
+				break
+			}
+			chars[pos] = uint16(report[4+2*i+1])<<8 | uint16(report[4+2*i])
+		}
+		emu.strbuf[report[1]] = chars
+
+		// commit the string once its last chunk has been received
+		if (index+1)*StrChunkSize >= length {
+			str := string(utf16.Decode(chars))
+			if report[1] == BASE_MANUFACTURER {
+				emu.NVDesc.Manufacturer = str
+			} else {
+				emu.NVDesc.Product = str
+			}
+			delete(emu.strbuf, report[1])
+		}
+	}
+}
+
+// Writes an output report and executes the command it carries.
+func (emu *Emulator) Write(report []byte) (int, error) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+
+	if emu.closed {
+		return 0, errEmuClosed
+	}
+
+	if len(report) != ReportSize {
+		return 0, errEmuReport
+	}
+
+	switch report[0] {
+	case BASE_CONFIGURE:
+		emu.baseConfig(report)
+	case SET_CLEAR_OUT:
+		emu.outputs = (emu.outputs | report[11]) &^ report[12]
+		emu.updatePort()
+	case CONFIGURE:
+		emu.Config.IO_Bmap = report[4]
+		emu.Config.Alt_Pins = report[5]
+		emu.Config.IO_Default = report[6]
+		emu.Config.Alt_Opts = report[7]
+		emu.Config.Baud_Rate_H = report[8]
+		emu.Config.Baud_Rate_L = report[9]
+		emu.updatePort()
+	case READ_EEPROM:
+		emu.Config.EEP_Addr = report[1]
+		emu.Config.EEP_Val = emu.EEPROM[report[1]]
+		emu.pending = append(emu.pending, emu.newReport(READ_EEPROM))
+	case WRITE_EEPROM:
+		emu.EEPROM[report[1]] = report[2]
+	case READ_ALL:
+		emu.pending = append(emu.pending, emu.newReport(READ_ALL))
+	}
+
+	return len(report), nil
+}
+
+// Reads the oldest queued input report.
+func (emu *Emulator) Read(report []byte) (int, error) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+
+	if emu.closed {
+		return 0, errEmuClosed
+	}
+
+	if len(emu.pending) == 0 {
+		return 0, errEmuNoReport
+	}
+
+	n := copy(report, emu.pending[0])
+	emu.pending = emu.pending[1:]
+	return n, nil
+}
+
+// Returns the manufacturer string descriptor.
+func (emu *Emulator) Manufacturer() (string, error) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return emu.Desc.Manufacturer, nil
+}
+
+// Returns the product string descriptor.
+func (emu *Emulator) Product() (string, error) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return emu.Desc.Product, nil
+}
+
+// Returns the serial number string descriptor.
+func (emu *Emulator) SerialNumber() (string, error) {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	return emu.Serial, nil
+}
+
+// Simulates a power cycle: descriptors written by BASE_CONFIGURE become
+// active and output latches are reloaded from IO_Default.
+func (emu *Emulator) Reset() error {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+
+	if emu.closed {
+		return errEmuClosed
+	}
+
+	emu.Desc = emu.NVDesc
+	emu.pending = nil
+	emu.powerOn()
+	return nil
+}
+
+// Marks the emulator closed.
+func (emu *Emulator) Close() error {
+	emu.mu.Lock()
+	defer emu.mu.Unlock()
+	emu.closed = true
+	return nil
+}
+
+// Handle to an emulator, which behaves like a handle to a real device:
+// it stops working once closed or once the device was reset and
+// re-enumerated. The emulator itself keeps its state.
+type emuHandle struct {
+	*Emulator
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Returns a new handle to the emulator.
+func (emu *Emulator) open() *emuHandle {
+	return &emuHandle{Emulator: emu}
+}
+
+// Returns errEmuClosed if the handle is no longer usable.
+func (h *emuHandle) check() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return errEmuClosed
+	}
+	return nil
+}
+
+// Writes an output report to the emulator.
+func (h *emuHandle) Write(report []byte) (int, error) {
+	if err := h.check(); err != nil {
+		return 0, err
+	}
+	return h.Emulator.Write(report)
+}
+
+// Reads an input report from the emulator.
+func (h *emuHandle) Read(report []byte) (int, error) {
+	if err := h.check(); err != nil {
+		return 0, err
+	}
+	return h.Emulator.Read(report)
+}
+
+// Returns the manufacturer string descriptor.
+func (h *emuHandle) Manufacturer() (string, error) {
+	if err := h.check(); err != nil {
+		return "", err
+	}
+	return h.Emulator.Manufacturer()
+}
+
+// Returns the product string descriptor.
+func (h *emuHandle) Product() (string, error) {
+	if err := h.check(); err != nil {
+		return "", err
+	}
+	return h.Emulator.Product()
+}
+
+// Returns the serial number string descriptor.
+func (h *emuHandle) SerialNumber() (string, error) {
+	if err := h.check(); err != nil {
+		return "", err
+	}
+	return h.Emulator.SerialNumber()
+}
+
+// Resets the emulator. The device re-enumerates, so the handle is
+// invalidated and the device has to be opened again.
+func (h *emuHandle) Reset() error {
+	if err := h.check(); err != nil {
+		return err
+	}
+	if err := h.Emulator.Reset(); err != nil {
+		return err
+	}
+	return h.Close()
+}
+
+// Closes the handle, the emulator keeps running.
+func (h *emuHandle) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	return nil
+}
@@ -0,0 +1,332 @@
+package usb
+
+import (
+	"errors"
+	"testing"
+)
+
+// Returns an MCP talking to a fresh emulator with its configuration read.
+func newTestMCP(t *testing.T) (*MCP, *Emulator) {
+	t.Helper()
+
+	emu := NewEmulator()
+	micro := &MCP{Transport: emu, VendID: emu.Desc.VendID, ProdID: emu.Desc.ProdID}
+
+	data, err := micro.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	micro.Data = data
+
+	return micro, emu
+}
+
+// Replaces the shared emulator with a fresh one for the duration of a test.
+func resetSharedEmulator(t *testing.T) {
+	t.Helper()
+
+	sharedMu.Lock()
+	sharedEmu = nil
+	sharedMu.Unlock()
+
+	t.Cleanup(func() {
+		sharedMu.Lock()
+		sharedEmu = nil
+		sharedMu.Unlock()
+	})
+}
+
+func TestEmulatorReadAll(t *testing.T) {
+	micro, _ := newTestMCP(t)
+
+	if micro.Data.OpCmd != READ_ALL {
+		t.Errorf("OpCmd = 0x%02X, want 0x%02X", micro.Data.OpCmd, READ_ALL)
+	}
+	if micro.Data.IO_Bmap != 0xFF {
+		t.Errorf("IO_Bmap = 0x%02X, want 0xFF", micro.Data.IO_Bmap)
+	}
+	if baud := BaudFromBytes(micro.Data.Baud_Rate_H, micro.Data.Baud_Rate_L); baud.Rate != 9600 {
+		t.Errorf("baud rate = %d, want 9600", baud.Rate)
+	}
+}
+
+func TestEmulatorConfigure(t *testing.T) {
+	micro, _ := newTestMCP(t)
+
+	micro.Data.IO_Bmap = 0xF0
+	micro.Data.Alt_Pins = ALT_TXLED | ALT_RXLED | 0x01
+	micro.Data.IO_Default = 0x05
+	micro.Data.Alt_Opts = ALT_INVERT | 0x04
+	micro.Data.Baud_Rate_H, micro.Data.Baud_Rate_L = 0x00, 0x67
+
+	if _, err := micro.ConfigCmd(); err != nil {
+		t.Fatalf("ConfigCmd: %v", err)
+	}
+
+	data, err := micro.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+
+	if changes := DiffConfig(micro.Data, data); len(changes) > 0 {
+		t.Errorf("configuration read back differs: %v", changes)
+	}
+}
+
+func TestEmulatorSetClearOutput(t *testing.T) {
+	micro, emu := newTestMCP(t)
+
+	// GP0-GP3 outputs, GP4-GP7 inputs
+	micro.Data.IO_Bmap = 0xF0
+	if err := micro.WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	emu.SetInputs(0xA0)
+
+	steps := []struct {
+		name string
+		run  func() error
+		want uint8
+	}{
+		{"set", func() error { return micro.SetPins(0x0F) }, 0xAF},
+		{"clear", func() error { return micro.ClearPins(0x03) }, 0xAC},
+		{"write", func() error { return micro.WritePins(0x05, 0x01) }, 0xA9},
+		{"toggle", func() error { return micro.TogglePins(0x0F) }, 0xA6},
+		{"inputs ignored", func() error { return micro.SetPins(0xF0) }, 0xA6},
+	}
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		port, err := micro.ReadPort()
+		if err != nil {
+			t.Fatalf("%s: ReadPort: %v", step.name, err)
+		}
+		if port != step.want {
+			t.Errorf("%s: port = 0x%02X, want 0x%02X", step.name, port, step.want)
+		}
+	}
+}
+
+func TestEmulatorEEPROM(t *testing.T) {
+	micro, _ := newTestMCP(t)
+
+	val, err := micro.ReadEEPROMCmd(0x10)
+	if err != nil {
+		t.Fatalf("ReadEEPROMCmd: %v", err)
+	}
+	if val != 0xFF {
+		t.Errorf("erased EEPROM byte = 0x%02X, want 0xFF", val)
+	}
+
+	if _, err := micro.WriteEEPROMCmd(0x10, 0x42); err != nil {
+		t.Fatalf("WriteEEPROMCmd: %v", err)
+	}
+	if val, err = micro.ReadEEPROMCmd(0x10); err != nil {
+		t.Fatalf("ReadEEPROMCmd: %v", err)
+	}
+	if val != 0x42 {
+		t.Errorf("EEPROM byte = 0x%02X, want 0x42", val)
+	}
+}
+
+func TestEmulatorBaseConfigureIDs(t *testing.T) {
+	micro, emu := newTestMCP(t)
+
+	if _, err := micro.SetVIDPIDCmd(0x1234, 0x5678); err != nil {
+		t.Fatalf("SetVIDPIDCmd: %v", err)
+	}
+	if emu.Desc.VendID != 0x04D8 || emu.Desc.ProdID != 0x00DF {
+		t.Errorf("IDs changed before reset: %04x:%04x", uint16(emu.Desc.VendID), uint16(emu.Desc.ProdID))
+	}
+
+	if err := emu.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if emu.Desc.VendID != 0x1234 || emu.Desc.ProdID != 0x5678 {
+		t.Errorf("IDs after reset = %04x:%04x, want 1234:5678", uint16(emu.Desc.VendID), uint16(emu.Desc.ProdID))
+	}
+
+	// the sub-command is ignored without the magic key
+	report := make([]byte, ReportSize)
+	report[0], report[1], report[4], report[6] = BASE_CONFIGURE, BASE_VID_PID, 0x11, 0x22
+	if _, err := emu.Write(report); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	emu.Reset()
+	if emu.Desc.VendID != 0x1234 {
+		t.Errorf("IDs changed without key: %04x", uint16(emu.Desc.VendID))
+	}
+}
+
+func TestEmulatorBaseConfigureStrings(t *testing.T) {
+	micro, emu := newTestMCP(t)
+
+	tests := []struct {
+		desc uint8
+		str  string
+		get  func() (string, error)
+	}{
+		{BASE_MANUFACTURER, "ACME Widgets Ltd.", emu.Manufacturer},
+		{BASE_PRODUCT, "Widget – rev 7 ✓", emu.Product},
+	}
+
+	for _, tc := range tests {
+		if err := micro.SetStringCmd(tc.desc, tc.str); err != nil {
+			t.Fatalf("SetStringCmd(%q): %v", tc.str, err)
+		}
+	}
+
+	if err := emu.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	for _, tc := range tests {
+		got, err := tc.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.str {
+			t.Errorf("string descriptor = %q, want %q", got, tc.str)
+		}
+	}
+}
+
+func TestEmulatorInvalidReports(t *testing.T) {
+	emu := NewEmulator()
+
+	if _, err := emu.Write(make([]byte, 8)); !errors.Is(err, errEmuReport) {
+		t.Errorf("short report: got %v, want %v", err, errEmuReport)
+	}
+
+	if _, err := emu.Read(make([]byte, ReportSize)); !errors.Is(err, errEmuNoReport) {
+		t.Errorf("read without request: got %v, want %v", err, errEmuNoReport)
+	}
+}
+
+func TestSharedEmulatorKeepsState(t *testing.T) {
+	resetSharedEmulator(t)
+
+	transport, err := Open(BackendEmulator, 0x04D8, 0x00DF, Filter{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	micro := &MCP{Transport: transport}
+	if _, err := micro.WriteEEPROMCmd(0x00, 0x5A); err != nil {
+		t.Fatalf("WriteEEPROMCmd: %v", err)
+	}
+	micro.Close()
+
+	if _, err := micro.ReadAll(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("closed handle: got %v, want %v", err, ErrDisconnected)
+	}
+
+	transport, err = Open(BackendEmulator, 0x04D8, 0x00DF, Filter{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	micro = &MCP{Transport: transport}
+	defer micro.Close()
+
+	val, err := micro.ReadEEPROMCmd(0x00)
+	if err != nil {
+		t.Fatalf("ReadEEPROMCmd: %v", err)
+	}
+	if val != 0x5A {
+		t.Errorf("EEPROM byte after reopen = 0x%02X, want 0x5A", val)
+	}
+}
+
+func TestSharedEmulatorNewIDs(t *testing.T) {
+	resetSharedEmulator(t)
+
+	transport, err := Open(BackendEmulator, 0x04D8, 0x00DF, Filter{})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	micro := &MCP{Transport: transport}
+
+	if _, err := micro.SetVIDPIDCmd(0x1234, 0x5678); err != nil {
+		t.Fatalf("SetVIDPIDCmd: %v", err)
+	}
+	if err := micro.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	// the device re-enumerated, the old handle is gone
+	if _, err := micro.ReadSerial(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("handle after reset: got %v, want %v", err, ErrDisconnected)
+	}
+
+	if _, err := Open(BackendEmulator, 0x04D8, 0x00DF, Filter{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open with old IDs: got %v, want %v", err, ErrNotFound)
+	}
+
+	list, err := List(BackendEmulator, 0x1234, 0x5678)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List with new IDs = %v, %v", list, err)
+	}
+
+	transport, err = Open(BackendEmulator, 0x1234, 0x5678, Filter{Serial: list[0].Serial})
+	if err != nil {
+		t.Fatalf("open with new IDs: %v", err)
+	}
+	transport.Close()
+}
+
+func TestWritesWithoutConfiguration(t *testing.T) {
+	emu := NewEmulator()
+	micro := &MCP{Transport: emu}
+
+	if err := micro.VerifyConfig(); err == nil {
+		t.Error("VerifyConfig without configuration: got nil error")
+	}
+	if err := micro.WriteConfig(); err == nil {
+		t.Error("WriteConfig without configuration: got nil error")
+	}
+
+	image := make([]byte, EEPROMSize)
+	for i := range image {
+		image[i] = uint8(i)
+	}
+	if err := micro.LoadEEPROM(image); err != nil {
+		t.Fatalf("LoadEEPROM: %v", err)
+	}
+	if got, err := micro.DumpEEPROM(); err != nil || string(got) != string(image) {
+		t.Errorf("DumpEEPROM = %X, %v, want %X", got, err, image)
+	}
+
+	if err := micro.WriteProduct("Widget"); err != nil {
+		t.Fatalf("WriteProduct: %v", err)
+	}
+	if micro.Data == nil {
+		t.Fatal("WriteProduct did not read a baseline configuration")
+	}
+	if err := micro.WriteVIDPID(0x1234, 0x5678); err != nil {
+		t.Fatalf("WriteVIDPID: %v", err)
+	}
+}
+
+func TestWritesWithoutRetries(t *testing.T) {
+	micro, emu := newTestMCP(t)
+
+	retries := WriteRetries
+	WriteRetries = -1
+	defer func() { WriteRetries = retries }()
+
+	micro.Data.IO_Default = 0xA5
+	if err := micro.WriteConfig(); err != nil {
+		t.Fatalf("WriteConfig: %v", err)
+	}
+	if emu.Config.IO_Default != 0xA5 {
+		t.Errorf("IO_Default = 0x%02X, want 0xA5", emu.Config.IO_Default)
+	}
+
+	if err := micro.WriteEEPROM(0x10, 0x5A); err != nil {
+		t.Fatalf("WriteEEPROM: %v", err)
+	}
+	if emu.EEPROM[0x10] != 0x5A {
+		t.Errorf("EEPROM[0x10] = 0x%02X, want 0x5A", emu.EEPROM[0x10])
+	}
+}
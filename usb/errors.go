@@ -0,0 +1,123 @@
+// Errors returned by device operations.
+
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/google/gousb"
+)
+
+// define kinds of device errors, test for them with errors.Is
+var (
+	ErrNotFound     = errors.New("device not found")
+	ErrPermission   = errors.New("permission denied")
+	ErrBusy         = errors.New("device busy")
+	ErrDisconnected = errors.New("device disconnected")
+	ErrTimeout      = errors.New("timeout")
+	ErrProtocol     = errors.New("protocol mismatch")
+)
+
+// Error describes a failed device operation.
+type Error struct {
+	// Operation that failed, e.g. "open" or "read".
+	Op string
+
+	// One of the error kinds above, nil if unknown.
+	Kind error
+
+	// Underlying error reported by the backend.
+	Err error
+}
+
+// Formats the error as "op: err".
+func (e *Error) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+// Returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reports whether the error is of the given kind.
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+// Wraps a backend error into an Error of the matching kind.
+// Errors that already are an Error are returned unchanged.
+func wrapErr(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var devErr *Error
+	if errors.As(err, &devErr) {
+		return err
+	}
+
+	return &Error{op, classify(err), err}
+}
+
+// Creates an Error of given kind from a message.
+func newErr(op string, kind error, format string, v ...interface{}) error {
+	return &Error{op, kind, fmt.Errorf(format, v...)}
+}
+
+// Returns the error kind of a libusb, system or emulator error.
+func classify(err error) error {
+	var usbErr gousb.Error
+	if errors.As(err, &usbErr) {
+		switch usbErr {
+		case gousb.ErrorAccess:
+			return ErrPermission
+		case gousb.ErrorBusy:
+			return ErrBusy
+		case gousb.ErrorNoDevice, gousb.ErrorNotFound, gousb.ErrorIO:
+			return ErrDisconnected
+		case gousb.ErrorTimeout:
+			return ErrTimeout
+		case gousb.ErrorOverflow, gousb.ErrorPipe:
+			return ErrProtocol
+		}
+		return nil
+	}
+
+	var status gousb.TransferStatus
+	if errors.As(err, &status) {
+		switch status {
+		case gousb.TransferNoDevice, gousb.TransferCancelled:
+			return ErrDisconnected
+		case gousb.TransferTimedOut:
+			return ErrTimeout
+		case gousb.TransferStall, gousb.TransferOverflow:
+			return ErrProtocol
+		}
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, errEmuClosed):
+		return ErrDisconnected
+	case errors.Is(err, errEmuNoReport):
+		return ErrTimeout
+	case errors.Is(err, errEmuReport):
+		return ErrProtocol
+	case os.IsPermission(err):
+		return ErrPermission
+	case os.IsTimeout(err):
+		return ErrTimeout
+	case os.IsNotExist(err):
+		return ErrNotFound
+	case errors.Is(err, syscall.EBUSY):
+		return ErrBusy
+	case errors.Is(err, syscall.ENODEV), errors.Is(err, syscall.EIO), errors.Is(err, os.ErrClosed):
+		return ErrDisconnected
+	}
+
+	return nil
+}
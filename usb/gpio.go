@@ -0,0 +1,138 @@
+// Runtime control of the GP0-GP7 pins.
+
+package usb
+
+import (
+	"fmt"
+)
+
+// Number of general purpose IO pins.
+const NumPins = 8
+
+// define GP pins shared with alternate functions
+const (
+	PIN_SSPND  = 0
+	PIN_USBCFG = 1
+	PIN_RXLED  = 6
+	PIN_TXLED  = 7
+)
+
+// Returns the mask of GP pins taken over by enabled alternate functions.
+func (gpio *AltPins) Mask() uint8 {
+	var mask uint8
+	if gpio.SSPND {
+		mask |= PinMask(PIN_SSPND)
+	}
+	if gpio.USBCFG {
+		mask |= PinMask(PIN_USBCFG)
+	}
+	if gpio.RxLED {
+		mask |= PinMask(PIN_RXLED)
+	}
+	if gpio.TxLED {
+		mask |= PinMask(PIN_TXLED)
+	}
+	return mask
+}
+
+// Returns the name of the alternate function sharing the given GP pin.
+func AltPinName(pin int) string {
+	switch pin {
+	case PIN_SSPND:
+		return "SSPND"
+	case PIN_USBCFG:
+		return "USBCFG"
+	case PIN_RXLED:
+		return "RxLED"
+	case PIN_TXLED:
+		return "TxLED"
+	}
+	return ""
+}
+
+// Returns the bit mask of the given GP pin, 0 for invalid pins.
+func PinMask(pin int) uint8 {
+	if pin < 0 || pin >= NumPins {
+		return 0
+	}
+	return 1 << uint(pin)
+}
+
+// Checks that pin is one of GP0-GP7.
+func checkPin(pin int) error {
+	if pin < 0 || pin >= NumPins {
+		return fmt.Errorf("invalid GPIO pin: %d", pin)
+	}
+	return nil
+}
+
+// Drives the masked output pins high.
+func (micro *MCP) SetPins(mask uint8) error {
+	_, err := micro.SetClearCmd(mask, 0)
+	return err
+}
+
+// Drives the masked output pins low.
+func (micro *MCP) ClearPins(mask uint8) error {
+	_, err := micro.SetClearCmd(0, mask)
+	return err
+}
+
+// Drives the masked output pins to the levels given in value.
+func (micro *MCP) WritePins(mask, value uint8) error {
+	_, err := micro.SetClearCmd(mask&value, mask&^value)
+	return err
+}
+
+// Inverts the masked output pins.
+func (micro *MCP) TogglePins(mask uint8) error {
+	port, err := micro.ReadPort()
+	if err != nil {
+		return err
+	}
+	return micro.WritePins(mask, ^port)
+}
+
+// Reads the live GPIO port value with READ_ALL command.
+func (micro *MCP) ReadPort() (uint8, error) {
+	data, err := micro.ReadAll()
+	if err != nil {
+		return 0, err
+	}
+
+	if micro.Data != nil {
+		micro.Data.IO_Port_Val = data.IO_Port_Val
+	}
+
+	return data.IO_Port_Val, nil
+}
+
+// Drives a single output pin high or low.
+func (micro *MCP) SetPin(pin int, high bool) error {
+	if err := checkPin(pin); err != nil {
+		return err
+	}
+
+	if high {
+		return micro.SetPins(PinMask(pin))
+	}
+	return micro.ClearPins(PinMask(pin))
+}
+
+// Inverts a single output pin.
+func (micro *MCP) TogglePin(pin int) error {
+	if err := checkPin(pin); err != nil {
+		return err
+	}
+	return micro.TogglePins(PinMask(pin))
+}
+
+// Reads the live level of a single pin.
+func (micro *MCP) ReadPin(pin int) (bool, error) {
+	if err := checkPin(pin); err != nil {
+		return false, err
+	}
+
+	port, err := micro.ReadPort()
+	return port&PinMask(pin) != 0, err
+}
@@ -0,0 +1,231 @@
+//go:build linux
+
+// Transport implementation using the Linux hidraw driver.
+
+package usb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Root of the hidraw class directory in sysfs.
+const hidrawClass = "/sys/class/hidraw"
+
+// USBDEVFS_RESET ioctl request number.
+const usbdevfsReset = 0x5514
+
+// Default timeout for reading input reports.
+const HIDRawTimeout = time.Second
+
+// HIDRaw represents an MCP2200 HID interface bound to the kernel
+// usbhid driver and exposed as /dev/hidrawN.
+type HIDRaw struct {
+	// Device node, e.g. /dev/hidraw0.
+	Path string
+
+	// sysfs directory of the parent USB device.
+	SysPath string
+
+	// USB port path, e.g. 1-1.2.
+	PortPath string
+
+	// Serial number, product string and release number reported by sysfs.
+	Serial      string
+	ProductName string
+	Release     string
+
+	// Timeout for reading input reports.
+	Timeout time.Duration
+
+	file *os.File
+}
+
+// Reads a single trimmed attribute file from sysfs.
+func readAttr(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Parses HID_ID entry from the uevent file of a HID device.
+func parseHIDID(hiddev string) (vid, pid ID, ok bool) {
+	file, err := os.Open(filepath.Join(hiddev, "uevent"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "HID_ID=") {
+			continue
+		}
+
+		var bus, v, p uint32
+		_, err := fmt.Sscanf(line[len("HID_ID="):], "%x:%x:%x", &bus, &v, &p)
+		if err != nil {
+			return
+		}
+		return ID(v), ID(p), true
+	}
+
+	return
+}
+
+// Walks sysfs and returns all hidraw nodes matching given VID/PID.
+func hidrawDevices(vid, pid ID) []*HIDRaw {
+	entries, err := ioutil.ReadDir(hidrawClass)
+	if err != nil {
+		return nil
+	}
+
+	var list []*HIDRaw
+	for _, entry := range entries {
+		hiddev, err := filepath.EvalSymlinks(filepath.Join(hidrawClass, entry.Name(), "device"))
+		if err != nil {
+			continue
+		}
+
+		v, p, ok := parseHIDID(hiddev)
+		if !ok || v != vid || p != pid {
+			continue
+		}
+
+		// HID device -> USB interface -> USB device
+		usbdev := filepath.Dir(filepath.Dir(hiddev))
+
+		raw := new(HIDRaw)
+		raw.Path = filepath.Join("/dev", entry.Name())
+		raw.SysPath = usbdev
+		raw.PortPath = filepath.Base(usbdev)
+		raw.Serial = readAttr(usbdev, "serial")
+		raw.ProductName = readAttr(usbdev, "product")
+		if bcd, err := strconv.ParseUint(readAttr(usbdev, "bcdDevice"), 16, 16); err == nil {
+			raw.Release = bcdRelease(uint16(bcd))
+		}
+		raw.Timeout = HIDRawTimeout
+		list = append(list, raw)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PortPath < list[j].PortPath
+	})
+
+	return list
+}
+
+// Returns the device info of the hidraw node.
+func (raw *HIDRaw) Info() DeviceInfo {
+	return DeviceInfo{Backend: BackendHIDRaw, Path: raw.PortPath, Node: raw.Path, Serial: raw.Serial, Product: raw.ProductName, Release: raw.Release}
+}
+
+// Finds the first hidraw node with given VID/PID matching the filter
+// and opens it.
+func OpenHIDRaw(VendID, ProdID ID, filter Filter) (*HIDRaw, error) {
+	for _, raw := range hidrawDevices(VendID, ProdID) {
+		if !filter.Match(raw.Info()) {
+			continue
+		}
+
+		file, err := os.OpenFile(raw.Path, os.O_RDWR, 0)
+		if err != nil {
+			return nil, wrapErr("open "+raw.Path, err)
+		}
+
+		raw.file = file
+		return raw, nil
+	}
+
+	vid, pid := util.UintToStr(uint16(VendID), uint16(ProdID))
+	return nil, newErr("open", ErrNotFound, "matching hidraw device not found [VendorID ProductID] %s %s", vid, pid)
+}
+
+// Writes an output report prefixed with the unnumbered report ID.
+func (raw *HIDRaw) Write(report []byte) (int, error) {
+	buf := make([]byte, len(report)+1)
+	copy(buf[1:], report)
+
+	n, err := raw.file.Write(buf)
+	if n > 0 {
+		n--
+	}
+	return n, err
+}
+
+// Reads an input report, waiting at most Timeout.
+func (raw *HIDRaw) Read(report []byte) (int, error) {
+	if raw.Timeout > 0 {
+		raw.file.SetReadDeadline(time.Now().Add(raw.Timeout))
+	}
+	return raw.file.Read(report)
+}
+
+// Reads the manufacturer string from sysfs.
+func (raw *HIDRaw) Manufacturer() (string, error) {
+	return raw.attr("manufacturer")
+}
+
+// Reads the product string from sysfs.
+func (raw *HIDRaw) Product() (string, error) {
+	return raw.attr("product")
+}
+
+// Reads the serial number string from sysfs.
+func (raw *HIDRaw) SerialNumber() (string, error) {
+	return raw.attr("serial")
+}
+
+// Reads given USB device attribute from sysfs.
+func (raw *HIDRaw) attr(name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(raw.SysPath, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Performs a USB port reset through the usbfs node of the parent device.
+// This requires write access to /dev/bus/usb/BBB/DDD.
+func (raw *HIDRaw) Reset() error {
+	bus, dev := util.StrToInt(readAttr(raw.SysPath, "busnum")), util.StrToInt(readAttr(raw.SysPath, "devnum"))
+	if bus == 0 || dev == 0 {
+		return errors.New("hidraw: could not find usbfs node")
+	}
+
+	node := fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, dev)
+	file, err := os.OpenFile(node, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), usbdevfsReset, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Closes the hidraw device node.
+func (raw *HIDRaw) Close() error {
+	if raw.file == nil {
+		return nil
+	}
+	return raw.file.Close()
+}
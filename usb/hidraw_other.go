@@ -0,0 +1,38 @@
+//go:build !linux
+
+// Stub of the hidraw backend for non-Linux platforms.
+
+package usb
+
+import (
+	"errors"
+	"time"
+)
+
+// HIDRaw is only available on Linux.
+type HIDRaw struct {
+	Transport
+
+	Path        string
+	SysPath     string
+	PortPath    string
+	Serial      string
+	ProductName string
+	Release     string
+	Timeout     time.Duration
+}
+
+// Returns the device info of the hidraw node.
+func (raw *HIDRaw) Info() DeviceInfo {
+	return DeviceInfo{Backend: BackendHIDRaw, Path: raw.PortPath, Node: raw.Path, Serial: raw.Serial, Product: raw.ProductName, Release: raw.Release}
+}
+
+// Returns no devices on platforms without hidraw.
+func hidrawDevices(vid, pid ID) []*HIDRaw {
+	return nil
+}
+
+// Reports that hidraw backend is not supported.
+func OpenHIDRaw(VendID, ProdID ID, filter Filter) (*HIDRaw, error) {
+	return nil, errors.New("hidraw backend is only supported on Linux")
+}
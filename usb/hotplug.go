@@ -0,0 +1,94 @@
+// Hotplug detection by periodic bus scans.
+
+package usb
+
+import (
+	"fmt"
+	"time"
+)
+
+// Default interval between two bus scans.
+const WatchInterval = time.Second
+
+// Represents a device being attached or detached.
+type Event struct {
+	Attached bool
+	Device   DeviceInfo
+}
+
+// Returns the key identifying a device between two scans.
+func (info DeviceInfo) key() string {
+	return fmt.Sprintf("%s/%s/%04x:%04x", info.Path, info.Serial, uint16(info.VendID), uint16(info.ProdID))
+}
+
+// Watch scans the backend for devices with given VID/PID every interval
+// and sends an event for each device attached or detached since the
+// previous scan. Devices found by the first scan are reported as attached.
+// Failed scans are skipped. The channel is closed once stop is closed.
+func Watch(backend string, VendID, ProdID ID, interval time.Duration, stop <-chan struct{}) <-chan Event {
+	return WatchIDs(backend, [][2]ID{{VendID, ProdID}}, interval, stop)
+}
+
+// Lists devices with any of the given Vendor/Product ID pairs.
+func listIDs(backend string, ids [][2]ID) ([]DeviceInfo, error) {
+	var list []DeviceInfo
+	for _, id := range ids {
+		found, err := List(backend, id[0], id[1])
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, found...)
+	}
+	return list, nil
+}
+
+// WatchIDs works like Watch for devices with any of the given
+// Vendor/Product ID pairs.
+func WatchIDs(backend string, ids [][2]ID, interval time.Duration, stop <-chan struct{}) <-chan Event {
+	events := make(chan Event)
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		send := func(event Event) bool {
+			select {
+			case events <- event:
+				return true
+			case <-stop:
+				return false
+			}
+		}
+
+		known := make(map[string]DeviceInfo)
+		for {
+			if list, err := listIDs(backend, ids); err == nil {
+				found := make(map[string]DeviceInfo)
+				for _, info := range list {
+					found[info.key()] = info
+					if _, ok := known[info.key()]; !ok && !send(Event{true, info}) {
+						return
+					}
+				}
+
+				for key, info := range known {
+					if _, ok := found[key]; !ok && !send(Event{false, info}) {
+						return
+					}
+				}
+
+				known = found
+			}
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return events
+}
@@ -0,0 +1,200 @@
+// Transport implementation using the gousb (libusb) library.
+
+package usb
+
+import (
+	"sort"
+
+	"github.com/google/gousb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// LibUSB represents all the gousb data structures
+// to interact with the USB device.
+type LibUSB struct {
+	Context   *gousb.Context
+	Device    *gousb.Device
+	Conf      *gousb.Config
+	Interface *gousb.Interface
+	InEP      *gousb.InEndpoint
+	OutEP     *gousb.OutEndpoint
+}
+
+// Initializes a new USB context object.
+func NewContext() *gousb.Context {
+	return gousb.NewContext()
+}
+
+// Opens the device with given VID/PID matching the filter
+// and claims its HID interface.
+func OpenLibUSB(VendID, ProdID ID, filter Filter) (*LibUSB, error) {
+	lib := new(LibUSB)
+	lib.Context = NewContext()
+
+	// open USB device with Vendor/Product ID
+	device, err := lib.OpenDevice(VendID, ProdID, filter)
+	if err != nil {
+		lib.Close()
+		return nil, err
+	}
+	lib.Device = device
+
+	// check if the USB device is connected
+	if lib.Device == nil {
+		vid, pid := util.UintToStr(uint16(VendID), uint16(ProdID))
+		lib.Close()
+		return nil, newErr("open", ErrNotFound, "matching USB device not found [VendorID ProductID] %s %s", vid, pid)
+	}
+
+	// enable Linux kernel driver auto detachment
+	lib.AutoDetach()
+
+	// initialize device configuration
+	if lib.Conf, err = lib.SelectConfig(); err != nil {
+		lib.Close()
+		return nil, err
+	}
+
+	// claim HID interface
+	if lib.Interface, err = lib.ClaimHIDInterface(); err != nil {
+		lib.Close()
+		return nil, err
+	}
+
+	// set In/Out Endpoints
+	if lib.InEP, err = lib.InEndpoint(); err != nil {
+		lib.Close()
+		return nil, err
+	}
+
+	if lib.OutEP, err = lib.OutEndpoint(); err != nil {
+		lib.Close()
+		return nil, err
+	}
+
+	return lib, nil
+}
+
+// Opens the first device with a given VID/PID matching the filter,
+// ordered by USB port path. All other devices are closed again.
+func (lib *LibUSB) OpenDevice(VendID, ProdID ID, filter Filter) (*gousb.Device, error) {
+	devices, err := lib.Context.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Vendor == VendID && desc.Product == ProdID
+	})
+	if err != nil && len(devices) == 0 {
+		return nil, wrapErr("open", err)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return PortPath(devices[i].Desc) < PortPath(devices[j].Desc)
+	})
+
+	var device *gousb.Device
+	for _, dev := range devices {
+		if device == nil && filter.Match(libusbInfo(dev)) {
+			device = dev
+			continue
+		}
+		dev.Close()
+	}
+
+	return device, nil
+}
+
+// Enables/disables automatic kernel driver detachment.
+func (lib *LibUSB) AutoDetach() {
+	lib.Device.SetAutoDetach(true)
+}
+
+// Select the device configuration number 0.
+func (lib *LibUSB) SelectConfig() (*gousb.Config, error) {
+	conf, err := lib.Device.Config(1)
+	if err != nil {
+		return nil, wrapErr("select config 1", err)
+	}
+
+	return conf, nil
+}
+
+// Claims the specified HID interface using a convenience function.
+// The default interface is always #0 alt #0 in the currently active config.
+func (lib *LibUSB) ClaimHIDInterface() (*gousb.Interface, error) {
+	intf, err := lib.Conf.Interface(2, 0)
+	if err != nil {
+		return nil, wrapErr("claim interface (2, 0)", err)
+	}
+
+	return intf, nil
+}
+
+// Prepares an IN endpoint for transfer.
+func (lib *LibUSB) InEndpoint() (*gousb.InEndpoint, error) {
+	input, err := lib.Interface.InEndpoint(1)
+	if err != nil {
+		return nil, wrapErr("open IN endpoint 1", err)
+	}
+
+	return input, nil
+}
+
+// Prepares an OUT endpoint for transfer.
+func (lib *LibUSB) OutEndpoint() (*gousb.OutEndpoint, error) {
+	output, err := lib.Interface.OutEndpoint(1)
+	if err != nil {
+		return nil, wrapErr("open OUT endpoint 1", err)
+	}
+
+	return output, nil
+}
+
+// Writes an output report via OutEndpoint.
+func (lib *LibUSB) Write(report []byte) (int, error) {
+	return lib.OutEP.Write(report)
+}
+
+// Reads an input report via InEndpoint.
+func (lib *LibUSB) Read(report []byte) (int, error) {
+	return lib.InEP.Read(report)
+}
+
+// Reads the manufacturer string descriptor.
+func (lib *LibUSB) Manufacturer() (string, error) {
+	return lib.Device.Manufacturer()
+}
+
+// Reads the product string descriptor.
+func (lib *LibUSB) Product() (string, error) {
+	return lib.Device.Product()
+}
+
+// Reads the serial number string descriptor.
+func (lib *LibUSB) SerialNumber() (string, error) {
+	return lib.Device.SerialNumber()
+}
+
+// Performs a USB port reset on the device.
+func (lib *LibUSB) Reset() error {
+	return lib.Device.Reset()
+}
+
+// Releases the interface, config, device and context in reverse order.
+func (lib *LibUSB) Close() error {
+	if lib.Interface != nil {
+		lib.Interface.Close()
+	}
+
+	if lib.Conf != nil {
+		lib.Conf.Close()
+	}
+
+	var err error
+	if lib.Device != nil {
+		err = lib.Device.Close()
+	}
+
+	if lib.Context != nil {
+		lib.Context.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,66 @@
+// Debug logging of the reports exchanged with the device.
+
+package usb
+
+import (
+	"fmt"
+
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// Logger receiving every report sent to or read from a device at debug
+// level. Nil disables report logging.
+var Log *util.Logger
+
+// Transport logging each report with the device serial number.
+type logTransport struct {
+	Transport
+	log *util.Logger
+}
+
+// Wraps the transport if a logger is set.
+func logged(transport Transport) Transport {
+	if Log == nil {
+		return transport
+	}
+	return &logTransport{Transport: transport}
+}
+
+// Writes an output report and logs it.
+func (t *logTransport) Write(report []byte) (int, error) {
+	n, err := t.Transport.Write(report)
+	t.logReport("Report sent", report, len(report), err)
+	return n, err
+}
+
+// Reads an input report and logs it.
+func (t *logTransport) Read(report []byte) (int, error) {
+	n, err := t.Transport.Read(report)
+	t.logReport("Report received", report, n, err)
+	return n, err
+}
+
+// Logs the opcode and the first n bytes of a report.
+func (t *logTransport) logReport(msg string, report []byte, n int, err error) {
+	if !Log.Enabled(util.LevelDebug) {
+		return
+	}
+
+	// the serial number is read once the first report is logged
+	if t.log == nil {
+		serial, _ := t.Transport.SerialNumber()
+		t.log = Log.With(util.F("serial", serial))
+	}
+
+	if err != nil {
+		t.log.Debug(msg, util.F("error", err))
+		return
+	}
+
+	report = report[:n]
+	var opcode string
+	if len(report) > 0 {
+		opcode = fmt.Sprintf("0x%02X", report[0])
+	}
+	t.log.Debug(msg, util.F("opcode", opcode), util.F("bytes", report))
+}
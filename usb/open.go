@@ -0,0 +1,185 @@
+// Backend selection and device enumeration.
+
+package usb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/gousb"
+	"github.com/korayeyinc/microconfig/util"
+)
+
+// define names of the transport backends
+const (
+	BackendLibUSB   = "libusb"
+	BackendHIDRaw   = "hidraw"
+	BackendEmulator = "emulator"
+)
+
+// Represents a matching device found through one of the backends.
+type DeviceInfo struct {
+	Backend string
+
+	// USB port path in sysfs notation, e.g. 1-1.2.
+	Path string
+
+	// Device node used by the backend, e.g. /dev/hidraw0.
+	Node string
+
+	Serial  string
+	Product string
+
+	// Device release number from bcdDevice, e.g. 1.00.
+	Release string
+
+	// USB IDs the device enumerated with.
+	VendID ID
+	ProdID ID
+}
+
+// Filter selects a single device among several connected ones.
+// Empty fields match any device.
+type Filter struct {
+	Serial string
+	Path   string
+}
+
+// Reports whether the device matches the filter.
+func (filter Filter) Match(info DeviceInfo) bool {
+	if filter.Serial != "" && filter.Serial != info.Serial {
+		return false
+	}
+
+	if filter.Path != "" && filter.Path != info.Path {
+		return false
+	}
+
+	return true
+}
+
+// Returns the USB port path of a device in sysfs notation, e.g. 1-1.2.
+func PortPath(desc *gousb.DeviceDesc) string {
+	ports := make([]string, len(desc.Path))
+	for i, port := range desc.Path {
+		ports[i] = util.IntToStr(port)
+	}
+
+	if len(ports) == 0 {
+		return fmt.Sprintf("usb%d", desc.Bus)
+	}
+	return fmt.Sprintf("%d-%s", desc.Bus, strings.Join(ports, "."))
+}
+
+// Sorts devices by USB port path.
+func sortDevices(list []DeviceInfo) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Path < list[j].Path
+	})
+}
+
+// Opens the first device matching the filter through the backend.
+// An empty backend name selects the libusb backend. Reports are logged
+// to Log at debug level.
+func Open(backend string, VendID, ProdID ID, filter Filter) (Transport, error) {
+	transport, err := openBackend(backend, VendID, ProdID, filter)
+	if err != nil {
+		return nil, err
+	}
+	return logged(transport), nil
+}
+
+// Opens the first device matching the filter through the backend.
+func openBackend(backend string, VendID, ProdID ID, filter Filter) (Transport, error) {
+	switch backend {
+	case "", BackendLibUSB:
+		lib, err := OpenLibUSB(VendID, ProdID, filter)
+		if err != nil {
+			return nil, err
+		}
+		return lib, nil
+	case BackendHIDRaw:
+		raw, err := OpenHIDRaw(VendID, ProdID, filter)
+		if err != nil {
+			return nil, err
+		}
+		return raw, nil
+	case BackendEmulator:
+		emu := sharedEmulator()
+		if !emu.matches(VendID, ProdID) || !filter.Match(emu.Info()) {
+			vid, pid := util.UintToStr(uint16(VendID), uint16(ProdID))
+			return nil, newErr("open", ErrNotFound, "matching emulated device not found [VendorID ProductID] %s %s", vid, pid)
+		}
+		return emu.open(), nil
+	}
+
+	return nil, fmt.Errorf("unknown backend: %s", backend)
+}
+
+// Lists devices with given VID/PID available through the backend,
+// ordered by USB port path.
+func List(backend string, VendID, ProdID ID) ([]DeviceInfo, error) {
+	var list []DeviceInfo
+
+	switch backend {
+	case "", BackendLibUSB:
+		var err error
+		if list, err = ListLibUSB(VendID, ProdID); err != nil {
+			return nil, err
+		}
+	case BackendHIDRaw:
+		for _, raw := range hidrawDevices(VendID, ProdID) {
+			info := raw.Info()
+			info.VendID, info.ProdID = VendID, ProdID
+			list = append(list, info)
+		}
+	case BackendEmulator:
+		if emu := sharedEmulator(); emu.matches(VendID, ProdID) {
+			list = append(list, emu.Info())
+		}
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", backend)
+	}
+
+	sortDevices(list)
+	return list, nil
+}
+
+// Returns the device info of an opened libusb device.
+func libusbInfo(dev *gousb.Device) DeviceInfo {
+	info := DeviceInfo{Backend: BackendLibUSB, Path: PortPath(dev.Desc)}
+	info.VendID, info.ProdID = dev.Desc.Vendor, dev.Desc.Product
+	info.Node = fmt.Sprintf("/dev/bus/usb/%03d/%03d", dev.Desc.Bus, dev.Desc.Address)
+	info.Serial, _ = dev.SerialNumber()
+	info.Product, _ = dev.Product()
+	info.Release = bcdRelease(uint16(dev.Desc.Device))
+	return info
+}
+
+// Formats a binary coded decimal release number as major.minor.
+func bcdRelease(bcd uint16) string {
+	return fmt.Sprintf("%x.%02x", bcd>>8, bcd&0xFF)
+}
+
+// Lists devices with given VID/PID visible to libusb.
+func ListLibUSB(VendID, ProdID ID) ([]DeviceInfo, error) {
+	ctx := NewContext()
+	defer ctx.Close()
+
+	devices, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		return desc.Vendor == VendID && desc.Product == ProdID
+	})
+	if err != nil && len(devices) == 0 {
+		return nil, wrapErr("list", err)
+	}
+
+	var list []DeviceInfo
+	for _, dev := range devices {
+		list = append(list, libusbInfo(dev))
+		dev.Close()
+	}
+
+	sortDevices(list)
+	return list, nil
+}
@@ -0,0 +1,31 @@
+// Transport abstraction for MCP2200 HID reports.
+
+package usb
+
+// Size of the MCP2200 HID input/output reports in bytes.
+const ReportSize = 16
+
+// Transport represents a backend capable of exchanging HID reports with
+// the MCP2200 and reading its USB string descriptors.
+type Transport interface {
+	// Writes a 16-byte output report to the device.
+	Write(report []byte) (int, error)
+
+	// Reads a 16-byte input report from the device.
+	Read(report []byte) (int, error)
+
+	// Reads the manufacturer string descriptor.
+	Manufacturer() (string, error)
+
+	// Reads the product string descriptor.
+	Product() (string, error)
+
+	// Reads the serial number string descriptor.
+	SerialNumber() (string, error)
+
+	// Resets the device.
+	Reset() error
+
+	// Releases all resources held by the backend.
+	Close() error
+}
@@ -1,9 +1,8 @@
-// Abstraction module for gousb library.
+// Abstraction module for MCP2200 USB device.
 package usb
 
 import (
 	"github.com/google/gousb"
-	"github.com/korayeyinc/microconfig/util"
 )
 
 // define alias for gousb.ID
@@ -26,30 +25,31 @@ type Data struct {
 
 // Represents Config_Alt_Options bitmap.
 type AltOpts struct {
-	HW_Flow string
-	Invert  string
-	LEDX    string
-	TxTGL   string
-	RxTGL   string
+	HW_Flow bool
+	Invert  bool
+	LEDX    bool
+	TxTGL   bool
+	RxTGL   bool
+
+	// Reserved bits as reported by the device.
+	Reserved uint8
 }
 
 // Represents Config_Alt_Pins bitmap.
 type AltPins struct {
-	SSPND  string
-	USBCFG string
-	RxLED  string
-	TxLED  string
+	SSPND  bool
+	USBCFG bool
+	RxLED  bool
+	TxLED  bool
+
+	// Reserved bits as reported by the device.
+	Reserved uint8
 }
 
 // MCP struct represents all the data structures
 // to interact with the USB device.
 type MCP struct {
-	Context   *gousb.Context
-	Device    *gousb.Device
-	Conf      *gousb.Config
-	Interface *gousb.Interface
-	InEP      *gousb.InEndpoint
-	OutEP     *gousb.OutEndpoint
+	Transport Transport
 	VendID    ID
 	ProdID    ID
 	*Data
@@ -65,142 +65,218 @@ const (
 	READ_ALL       = 0x80
 )
 
-// Initializes a new USB context object.
-func NewContext() *gousb.Context {
-	return gousb.NewContext()
-}
+// define bits of Config_Alt_Options bitmap
+const (
+	ALT_HW_FLOW = 1 << 0
+	ALT_INVERT  = 1 << 1
+	ALT_LEDX    = 1 << 5
+	ALT_TXTGL   = 1 << 6
+	ALT_RXTGL   = 1 << 7
 
-// Opens any device with a given VID/PID using a convenience function.
-func (micro *MCP) OpenDevice(VendID, ProdID ID) *gousb.Device {
-	device, err := micro.Context.OpenDeviceWithVIDPID(VendID, ProdID)
-	if err != nil {
-		util.Fatalf("Could not open device: %v", err)
-	}
+	AltOptsReserved = 0x1C
+)
+
+// define bits of Config_Alt_Pins bitmap
+const (
+	ALT_TXLED  = 1 << 2
+	ALT_RXLED  = 1 << 3
+	ALT_USBCFG = 1 << 6
+	ALT_SSPND  = 1 << 7
+
+	AltPinsReserved = 0x33
+)
+
+// define sub-commands for BASE_CONFIGURE opcode
+const (
+	BASE_VID_PID      = 0x00
+	BASE_MANUFACTURER = 0x01
+	BASE_PRODUCT      = 0x02
+)
+
+// Magic bytes guarding the BASE_CONFIGURE VID/PID sub-command.
+const (
+	BASE_KEY_H = 0xAB
+	BASE_KEY_L = 0xEF
+)
+
+// Number of UTF-16 characters carried by a single string descriptor chunk.
+const StrChunkSize = 6
+
+// Maximum length of manufacturer/product string descriptors in characters.
+const MaxStrLen = 63
+
+// Size of the MCP2200 user EEPROM in bytes.
+const EEPROMSize = 256
+
+// Number of meaningful bytes in a READ_ALL response.
+const DataSize = 11
 
-	return device
+// Reload performs a USB port reset to reinitialize a device.
+func (micro *MCP) Reload() error {
+	return wrapErr("reset", micro.Transport.Reset())
 }
 
-// Enables/disables automatic kernel driver detachment.
-func (micro *MCP) AutoDetach() {
-	micro.Device.SetAutoDetach(true)
+// Closes the underlying transport.
+func (micro *MCP) Close() {
+	micro.Transport.Close()
 }
 
-// Select the device configuration number 0.
-func (micro *MCP) SelectConfig() *gousb.Config {
-	conf, err := micro.Device.Config(1)
+// Reads device manufacturer information.
+func (micro *MCP) ReadManufacturer() (string, error) {
+	manufacturer, err := micro.Transport.Manufacturer()
 	if err != nil {
-		util.Fatalf("%s.Config(0): %v", micro.Device, err)
+		return "", wrapErr("read manufacturer", err)
 	}
-
-	return conf
+	return manufacturer, nil
 }
 
-// Claims the specified HID interface using a convenience function.
-// The default interface is always #0 alt #0 in the currently active config.
-func (micro *MCP) ClaimHIDInterface() *gousb.Interface {
-	intf, err := micro.Conf.Interface(2, 0)
+// Reads device's product name.
+func (micro *MCP) ReadProduct() (string, error) {
+	product, err := micro.Transport.Product()
 	if err != nil {
-		util.Fatalf("%s.Interface(2, 0): %v", micro.Conf, err)
+		return "", wrapErr("read product", err)
 	}
-
-	return intf
+	return product, nil
 }
 
-//Prepares an IN endpoint for transfer.
-func (micro *MCP) InEndpoint() *gousb.InEndpoint {
-	input, err := micro.Interface.InEndpoint(1)
+// Reads device's serial number.
+func (micro *MCP) ReadSerial() (string, error) {
+	serial, err := micro.Transport.SerialNumber()
 	if err != nil {
-		util.Fatalf("%s.InEndpoint(1): %v", micro.Interface, err)
+		return "", wrapErr("read serial number", err)
 	}
-
-	return input
+	return serial, nil
 }
 
-//Prepares an OUT endpoint for transfer.
-func (micro *MCP) OutEndpoint() *gousb.OutEndpoint {
-	output, err := micro.Interface.OutEndpoint(1)
+// Sends READ_ALL command to MCP2200.
+func (micro *MCP) ReadAllCmd() (int, error) {
+	buf := make([]byte, ReportSize)
+	buf[0] = READ_ALL
+
+	// write READ_ALL command opcode via transport.
+	val, err := micro.Transport.Write(buf)
+
 	if err != nil {
-		util.Fatalf("%s.OutEndpoint(1): %v", micro.Interface, err)
+		return val, wrapErr("write", err)
 	}
 
-	return output
+	return val, nil
 }
 
-// Reload performs a USB port reset to reinitialize a device.
-func (micro *MCP) Reload() bool {
-	err := micro.Device.Reset()
-	util.Check(err)
-	return true
-}
+// Sends READ_ALL command and returns the parsed response.
+func (micro *MCP) ReadAll() (*Data, error) {
+	if _, err := micro.ReadAllCmd(); err != nil {
+		return nil, err
+	}
 
-// Reads device manufacturer information.
-func (micro *MCP) ReadManufacturer() string {
-	manufacturer, err := micro.Device.Manufacturer()
+	data, err := micro.ParseResponse()
 	if err != nil {
-		util.Fatalf("Could not read device manufacturer: %v", err)
+		return nil, err
 	}
-	return manufacturer
+
+	if data.OpCmd != READ_ALL {
+		return nil, newErr("read", ErrProtocol, "unexpected response 0x%02X to READ_ALL", data.OpCmd)
+	}
+
+	return data, nil
 }
 
-// Reads device's product name.
-func (micro *MCP) ReadProduct() string {
-	product, err := micro.Device.Product()
+// Sends the CONFIGURE command to MCP2200.
+func (micro *MCP) ConfigCmd() (int, error) {
+	data := micro.NewReqData()
+
+	// write CONFIGURE command opcode via transport.
+	val, err := micro.Transport.Write(data)
+
 	if err != nil {
-		util.Fatalf("Could not read device's product name: %v", err)
+		return val, wrapErr("write", err)
 	}
-	return product
+
+	return val, nil
 }
 
-// Reads device's serial number.
-func (micro *MCP) ReadSerial() string {
-	serial, err := micro.Device.SerialNumber()
+// Sends the SET_CLEAR_OUTPUT command to MCP2200.
+func (micro *MCP) SetClearCmd(set, clear uint8) (int, error) {
+	buf := make([]byte, ReportSize)
+	buf[0] = SET_CLEAR_OUT
+	buf[11] = set
+	buf[12] = clear
+
+	// write SET_CLEAR_OUTPUT command opcode via transport.
+	val, err := micro.Transport.Write(buf)
+
 	if err != nil {
-		util.Fatalf("Could not read device's serial number: %v", err)
+		return val, wrapErr("write", err)
 	}
-	return serial
+
+	return val, nil
 }
 
-// Sends READ_ALL command to MCP2200.
-func (micro *MCP) ReadAllCmd() int {
-	buf := make([]byte, 16)
-	buf[0] = READ_ALL
+// Sends the READ_EEPROM command to MCP2200 and returns the stored value.
+func (micro *MCP) ReadEEPROMCmd(addr uint8) (uint8, error) {
+	buf := make([]byte, ReportSize)
+	buf[0] = READ_EEPROM
+	buf[1] = addr
 
-	// write READ_ALL command opcode via OutEndpoint.
-	val, err := micro.OutEP.Write(buf)
+	// write READ_EEPROM command opcode via transport.
+	_, err := micro.Transport.Write(buf)
 
 	if err != nil {
-		util.Fatalf("%s.Write: got error %v:", micro.OutEP, err)
+		return 0, wrapErr("write", err)
 	}
 
-	return val
+	data, err := micro.ParseResponse()
+	if err != nil {
+		return 0, err
+	}
+
+	if data.OpCmd != READ_EEPROM || data.EEP_Addr != addr {
+		return 0, newErr("read", ErrProtocol, "unexpected response 0x%02X 0x%02X to READ_EEPROM 0x%02X", data.OpCmd, data.EEP_Addr, addr)
+	}
+
+	return data.EEP_Val, nil
 }
 
-// Sends the CONFIGURE command to MCP2200.
-func (micro *MCP) ConfigCmd() int {
-	data := micro.NewReqData()
+// Sends the WRITE_EEPROM command to MCP2200.
+func (micro *MCP) WriteEEPROMCmd(addr, value uint8) (int, error) {
+	buf := make([]byte, ReportSize)
+	buf[0] = WRITE_EEPROM
+	buf[1] = addr
+	buf[2] = value
 
-	// write CONFIGURE command opcode via OutEndpoint.
-	val, err := micro.OutEP.Write(data)
+	// write WRITE_EEPROM command opcode via transport.
+	val, err := micro.Transport.Write(buf)
 
 	if err != nil {
-		util.Fatalf("%s.Write: got error %v:", micro.OutEP, err)
+		return val, wrapErr("write", err)
 	}
 
-	return val
+	return val, nil
 }
 
 // Parses READ_ALL command response.
-func (micro *MCP) ParseResponse() *Data {
-	buf := make([]byte, 16)
+func (micro *MCP) ParseResponse() (*Data, error) {
+	buf := make([]byte, ReportSize)
 
-	// read READ_ALL command response via InEndpoint.
-	_, err := micro.InEP.Read(buf)
+	// read READ_ALL command response via transport.
+	n, err := micro.Transport.Read(buf)
 
 	if err != nil {
-		util.Fatalf("%s.Read: got error %v:", micro.InEP, err)
+		return nil, wrapErr("read", err)
+	}
+
+	if n < DataSize {
+		return nil, newErr("read", ErrProtocol, "short response of %d bytes", n)
 	}
 
 	data := new(Data)
+	data.UnmarshalBytes(buf)
+
+	return data, nil
+}
+
+// Decodes the first DataSize bytes of a READ_ALL response.
+func (data *Data) UnmarshalBytes(buf []byte) {
 	data.OpCmd = buf[0]
 	data.EEP_Addr = buf[1]
 	data.Reserved1 = buf[2]
@@ -212,36 +288,68 @@ func (micro *MCP) ParseResponse() *Data {
 	data.Baud_Rate_H = buf[8]
 	data.Baud_Rate_L = buf[9]
 	data.IO_Port_Val = buf[10]
+}
+
+// Encodes the data in READ_ALL response layout.
+func (data *Data) MarshalBytes() []byte {
+	return []byte{
+		data.OpCmd, data.EEP_Addr, data.Reserved1, data.EEP_Val,
+		data.IO_Bmap, data.Alt_Pins, data.IO_Default, data.Alt_Opts,
+		data.Baud_Rate_H, data.Baud_Rate_L, data.IO_Port_Val,
+	}
+}
 
-	return data
+// Decodes the Config_Alt_Options bitmap. Reserved bits are kept.
+func (opts *AltOpts) UnmarshalByte(bitmap uint8) {
+	opts.HW_Flow = bitmap&ALT_HW_FLOW != 0
+	opts.Invert = bitmap&ALT_INVERT != 0
+	opts.LEDX = bitmap&ALT_LEDX != 0
+	opts.TxTGL = bitmap&ALT_TXTGL != 0
+	opts.RxTGL = bitmap&ALT_RXTGL != 0
+	opts.Reserved = bitmap & AltOptsReserved
 }
 
-// Parses Alt_Opts bitmap.
-func (micro *MCP) ParseAltOpts(bitmap uint8) *AltOpts {
-	opts := new(AltOpts)
-	opts.HW_Flow = util.GetBit(bitmap, 0)
-	opts.Invert = util.GetBit(bitmap, 1)
-	opts.LEDX = util.GetBit(bitmap, 5)
-	opts.TxTGL = util.GetBit(bitmap, 6)
-	opts.RxTGL = util.GetBit(bitmap, 7)
+// Encodes the Config_Alt_Options bitmap.
+func (opts *AltOpts) MarshalByte() uint8 {
+	bitmap := opts.Reserved & AltOptsReserved
+	bitmap |= setBit(opts.HW_Flow, ALT_HW_FLOW)
+	bitmap |= setBit(opts.Invert, ALT_INVERT)
+	bitmap |= setBit(opts.LEDX, ALT_LEDX)
+	bitmap |= setBit(opts.TxTGL, ALT_TXTGL)
+	bitmap |= setBit(opts.RxTGL, ALT_RXTGL)
+	return bitmap
+}
 
-	return opts
+// Decodes the Config_Alt_Pins bitmap. Reserved bits are kept.
+func (gpio *AltPins) UnmarshalByte(bitmap uint8) {
+	gpio.TxLED = bitmap&ALT_TXLED != 0
+	gpio.RxLED = bitmap&ALT_RXLED != 0
+	gpio.USBCFG = bitmap&ALT_USBCFG != 0
+	gpio.SSPND = bitmap&ALT_SSPND != 0
+	gpio.Reserved = bitmap & AltPinsReserved
 }
 
-// Parses Alt_Pins bitmap.
-func (micro *MCP) ParseAltPins(bitmap uint8) *AltPins {
-	gpio := new(AltPins)
-	gpio.SSPND = util.GetBit(bitmap, 7)
-	gpio.USBCFG = util.GetBit(bitmap, 6)
-	gpio.RxLED = util.GetBit(bitmap, 3)
-	gpio.TxLED = util.GetBit(bitmap, 2)
+// Encodes the Config_Alt_Pins bitmap.
+func (gpio *AltPins) MarshalByte() uint8 {
+	bitmap := gpio.Reserved & AltPinsReserved
+	bitmap |= setBit(gpio.TxLED, ALT_TXLED)
+	bitmap |= setBit(gpio.RxLED, ALT_RXLED)
+	bitmap |= setBit(gpio.USBCFG, ALT_USBCFG)
+	bitmap |= setBit(gpio.SSPND, ALT_SSPND)
+	return bitmap
+}
 
-	return gpio
+// Returns mask if the flag is set, 0 otherwise.
+func setBit(flag bool, mask uint8) uint8 {
+	if flag {
+		return mask
+	}
+	return 0
 }
 
 // Creates new request data for CONFIGURE command.
 func (micro *MCP) NewReqData() []byte {
-	buf := make([]byte, 16)
+	buf := make([]byte, ReportSize)
 	buf[0] = CONFIGURE
 	buf[4] = micro.Data.IO_Bmap
 	buf[5] = micro.Data.Alt_Pins
@@ -252,36 +360,3 @@ func (micro *MCP) NewReqData() []byte {
 
 	return buf
 }
-
-// Gets baud rate value.
-func (micro *MCP) GetBaudRate(high, low uint8) int {
-	baud_rate := util.ConcatBytes(high, low)
-	return 12000000 / (int(baud_rate) + 1)
-}
-
-// Calculates high/low byte values.
-func (micro *MCP) CalcHLBytes(baud string) (high, low uint8) {
-	baud_rate := util.StrToInt(baud)
-	divisor := 12000000/baud_rate - 1
-	high, low = util.SplitBytes(uint16(divisor))
-	return
-}
-
-// Returns active baud rate index
-func (micro *MCP) GetBaudRateIndex(input string) int {
-	baudrates := [12]string{
-		"300", "1200", "2400", "4800", "9600", "19200",
-		"38400", "57600", "115200", "230400", "460800", "921600",
-	}
-
-	var index int
-	var baud string
-
-	for index, baud = range baudrates {
-		if baud == input {
-			break
-		}
-	}
-
-	return index
-}
@@ -0,0 +1,89 @@
+package usb
+
+import "testing"
+
+func TestAltOptsBits(t *testing.T) {
+	tests := []struct {
+		bitmap uint8
+		want   AltOpts
+	}{
+		{0x00, AltOpts{}},
+		{ALT_HW_FLOW, AltOpts{HW_Flow: true}},
+		{ALT_INVERT, AltOpts{Invert: true}},
+		{ALT_LEDX, AltOpts{LEDX: true}},
+		{ALT_TXTGL, AltOpts{TxTGL: true}},
+		{ALT_RXTGL, AltOpts{RxTGL: true}},
+		{AltOptsReserved, AltOpts{Reserved: AltOptsReserved}},
+		{0x04, AltOpts{Reserved: 0x04}},
+		{0xFF, AltOpts{true, true, true, true, true, AltOptsReserved}},
+	}
+
+	for _, tc := range tests {
+		var got AltOpts
+		got.UnmarshalByte(tc.bitmap)
+		if got != tc.want {
+			t.Errorf("UnmarshalByte(0x%02X) = %+v, want %+v", tc.bitmap, got, tc.want)
+		}
+		if b := tc.want.MarshalByte(); b != tc.bitmap {
+			t.Errorf("MarshalByte(%+v) = 0x%02X, want 0x%02X", tc.want, b, tc.bitmap)
+		}
+	}
+}
+
+func TestAltPinsBits(t *testing.T) {
+	tests := []struct {
+		bitmap uint8
+		want   AltPins
+	}{
+		{0x00, AltPins{}},
+		{ALT_TXLED, AltPins{TxLED: true}},
+		{ALT_RXLED, AltPins{RxLED: true}},
+		{ALT_USBCFG, AltPins{USBCFG: true}},
+		{ALT_SSPND, AltPins{SSPND: true}},
+		{AltPinsReserved, AltPins{Reserved: AltPinsReserved}},
+		{0x21, AltPins{Reserved: 0x21}},
+		{0xFF, AltPins{true, true, true, true, AltPinsReserved}},
+	}
+
+	for _, tc := range tests {
+		var got AltPins
+		got.UnmarshalByte(tc.bitmap)
+		if got != tc.want {
+			t.Errorf("UnmarshalByte(0x%02X) = %+v, want %+v", tc.bitmap, got, tc.want)
+		}
+		if b := tc.want.MarshalByte(); b != tc.bitmap {
+			t.Errorf("MarshalByte(%+v) = 0x%02X, want 0x%02X", tc.want, b, tc.bitmap)
+		}
+	}
+}
+
+func TestAltBitmapsRoundTrip(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		bitmap := uint8(i)
+
+		var opts AltOpts
+		opts.UnmarshalByte(bitmap)
+		if got := opts.MarshalByte(); got != bitmap {
+			t.Errorf("AltOpts 0x%02X encodes to 0x%02X", bitmap, got)
+		}
+
+		var pins AltPins
+		pins.UnmarshalByte(bitmap)
+		if got := pins.MarshalByte(); got != bitmap {
+			t.Errorf("AltPins 0x%02X encodes to 0x%02X", bitmap, got)
+		}
+	}
+}
+
+func TestAltBitmapsIgnoreForeignReservedBits(t *testing.T) {
+	// reserved bits outside the mask are not encoded
+	opts := AltOpts{Reserved: 0xFF}
+	if got := opts.MarshalByte(); got != AltOptsReserved {
+		t.Errorf("AltOpts{Reserved: 0xFF} encodes to 0x%02X, want 0x%02X", got, AltOptsReserved)
+	}
+
+	pins := AltPins{Reserved: 0xFF}
+	if got := pins.MarshalByte(); got != AltPinsReserved {
+		t.Errorf("AltPins{Reserved: 0xFF} encodes to 0x%02X, want 0x%02X", got, AltPinsReserved)
+	}
+}
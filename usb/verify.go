@@ -0,0 +1,121 @@
+// Verified writes: every write is followed by READ_ALL and repeated
+// while the device reports other values than written.
+
+package usb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Number of times a write is repeated after verification failed.
+var WriteRetries = 3
+
+// Mismatch lists the fields the device reported with other values than
+// written. Old holds the written and New the value read back.
+type Mismatch []Change
+
+// Formats the mismatching fields as "field: wrote old, read new".
+func (mismatch Mismatch) Error() string {
+	fields := make([]string, len(mismatch))
+	for i, change := range mismatch {
+		fields[i] = fmt.Sprintf("%s: wrote %s, read %s", change.Field, change.Old, change.New)
+	}
+	return strings.Join(fields, "; ")
+}
+
+// Reports whether verification failed because of mismatching fields.
+func isMismatch(err error) bool {
+	var mismatch Mismatch
+	return errors.As(err, &mismatch)
+}
+
+// Compares the configuration reported by READ_ALL with micro.Data.
+func (micro *MCP) VerifyConfig() error {
+	if micro.Data == nil {
+		return newErr("verify", nil, "no configuration read to verify against")
+	}
+
+	data, err := micro.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	if changes := DiffConfig(micro.Data, data); len(changes) > 0 {
+		return &Error{"verify", ErrProtocol, Mismatch(changes)}
+	}
+
+	return nil
+}
+
+// Compares the string descriptors reported by the device with the given
+// ones. Written strings are only reported after the device was reset.
+func (micro *MCP) VerifyStrings(manufacturer, product string) error {
+	var mismatch Mismatch
+
+	got, err := micro.ReadManufacturer()
+	if err != nil {
+		return err
+	}
+	if got != manufacturer {
+		mismatch = append(mismatch, Change{"Manufacturer", manufacturer, got})
+	}
+
+	if got, err = micro.ReadProduct(); err != nil {
+		return err
+	}
+	if got != product {
+		mismatch = append(mismatch, Change{"Product", product, got})
+	}
+
+	if len(mismatch) > 0 {
+		return &Error{"verify", ErrProtocol, mismatch}
+	}
+
+	return nil
+}
+
+// Runs write followed by VerifyConfig, repeating both up to WriteRetries
+// times while the configuration read back differs from micro.Data.
+// If micro.Data is nil, the configuration is read first as baseline.
+func (micro *MCP) verified(write func() error) error {
+	if micro.Data == nil {
+		data, err := micro.ReadAll()
+		if err != nil {
+			return err
+		}
+		micro.Data = data
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := write(); err != nil {
+			return err
+		}
+
+		if err := micro.VerifyConfig(); !isMismatch(err) || attempt >= WriteRetries {
+			return err
+		}
+	}
+}
+
+// Sends the CONFIGURE command with micro.Data and verifies the result.
+func (micro *MCP) WriteConfig() error {
+	if micro.Data == nil {
+		return newErr("configure", nil, "no configuration to write")
+	}
+
+	return micro.verified(func() error {
+		_, err := micro.ConfigCmd()
+		return err
+	})
+}
+
+// Writes new Vendor/Product IDs and verifies that the configuration
+// was left untouched. The IDs take effect after the next reset.
+func (micro *MCP) WriteVIDPID(VendID, ProdID ID) error {
+	return micro.verified(func() error {
+		_, err := micro.SetVIDPIDCmd(VendID, ProdID)
+		return err
+	})
+}
@@ -0,0 +1,39 @@
+// Log sink writing to the systemd journal.
+
+package util
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+// Maps log levels to journal priorities.
+var journalPriority = map[Level]journal.Priority{
+	LevelDebug: journal.PriDebug,
+	LevelInfo:  journal.PriInfo,
+	LevelWarn:  journal.PriWarning,
+	LevelError: journal.PriErr,
+}
+
+// JournalSink sends records to the systemd journal. Fields are stored
+// as journal fields with upper case names, e.g. SERIAL=0001234567.
+type JournalSink struct{}
+
+// Returns a journal sink if the journal is available.
+func NewJournalSink() (JournalSink, error) {
+	if !journal.Enabled() {
+		return JournalSink{}, errors.New("systemd journal not available")
+	}
+	return JournalSink{}, nil
+}
+
+// Sends the record to the journal.
+func (JournalSink) Write(r *Record) error {
+	vars := make(map[string]string, len(r.Fields))
+	for _, field := range r.Fields {
+		vars[strings.ToUpper(field.Key)] = field.String()
+	}
+	return journal.Send(r.Message, journalPriority[r.Level], vars)
+}
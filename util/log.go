@@ -0,0 +1,284 @@
+// Structured logging with levels, fields and pluggable sinks.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record.
+type Level int
+
+// define log levels, LevelOff disables a sink
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR", "OFF"}
+
+// Returns the upper case name of the level.
+func (level Level) String() string {
+	if level < LevelDebug || level > LevelOff {
+		return fmt.Sprintf("LEVEL(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// Parses a level name such as "info" or "WARN".
+func ParseLevel(str string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(str, name) {
+			return Level(i), nil
+		}
+	}
+	return LevelOff, fmt.Errorf("invalid log level %q", str)
+}
+
+// Field is a named value attached to a log record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Returns a log field.
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// Formats the field value. Byte slices are printed as hex.
+func (field Field) String() string {
+	if buf, ok := field.Value.([]byte); ok {
+		return fmt.Sprintf("%X", buf)
+	}
+	return fmt.Sprint(field.Value)
+}
+
+// Record is a single log message with its fields.
+type Record struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+}
+
+// Formats the fields as key=value pairs, each preceded by a space.
+// Values holding spaces or quotes are quoted.
+func (r *Record) FieldText() string {
+	var b strings.Builder
+	for _, field := range r.Fields {
+		value := field.String()
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", field.Key, value)
+	}
+	return b.String()
+}
+
+// Formats the record as a single line with timestamp.
+func (r *Record) String() string {
+	return fmt.Sprintf("%s %-5s %s%s", r.Time.Format("2006-01-02T15:04:05.000Z07:00"), r.Level, r.Message, r.FieldText())
+}
+
+// Sink receives the records of a logger.
+type Sink interface {
+	Write(r *Record) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(r *Record) error
+
+// Calls the function with the record.
+func (fn SinkFunc) Write(r *Record) error {
+	return fn(r)
+}
+
+// StreamSink writes records line by line to a writer such as os.Stderr.
+type StreamSink struct {
+	W io.Writer
+}
+
+// Writes the record as a single line.
+func (sink StreamSink) Write(r *Record) error {
+	_, err := fmt.Fprintln(sink.W, r)
+	return err
+}
+
+// FileSink appends records to a file, which is rotated once it exceeds
+// its size limit. Up to backups old files are kept as name.1, name.2...
+type FileSink struct {
+	name    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+}
+
+// Opens a log file for appending.
+func NewFileSink(filename string, maxSize int64, backups int) (*FileSink, error) {
+	sink := &FileSink{name: filename, maxSize: maxSize, backups: backups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Opens the log file and takes its current size.
+func (sink *FileSink) open() error {
+	file, err := os.OpenFile(sink.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	sink.file, sink.size = file, info.Size()
+	return nil
+}
+
+// Moves the log file to name.1, shifting older files up by one.
+// The oldest file is dropped.
+func (sink *FileSink) rotate() error {
+	if err := sink.file.Close(); err != nil {
+		return err
+	}
+
+	for i := sink.backups; i > 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", sink.name, i-1), fmt.Sprintf("%s.%d", sink.name, i))
+	}
+
+	if sink.backups > 0 {
+		if err := os.Rename(sink.name, sink.name+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(sink.name); err != nil {
+		return err
+	}
+
+	return sink.open()
+}
+
+// Appends the record to the log file, rotating it first if the record
+// does not fit.
+func (sink *FileSink) Write(r *Record) error {
+	line := r.String() + "\n"
+
+	if sink.size > 0 && sink.size+int64(len(line)) > sink.maxSize {
+		if err := sink.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := sink.file.WriteString(line)
+	sink.size += int64(n)
+	return err
+}
+
+// Closes the log file.
+func (sink *FileSink) Close() error {
+	return sink.file.Close()
+}
+
+// Represents a sink with its verbosity.
+type logSink struct {
+	sink  Sink
+	level Level
+}
+
+// Holds the sinks shared by a logger and the loggers derived from it.
+type logCore struct {
+	mu    sync.Mutex
+	sinks []logSink
+}
+
+// Logger sends records to sinks, each with its own verbosity.
+// A logger is safe for concurrent use.
+type Logger struct {
+	core   *logCore
+	fields []Field
+}
+
+// Returns a logger without sinks, which drops all records.
+func NewLogger() *Logger {
+	return &Logger{core: new(logCore)}
+}
+
+// Adds a sink receiving records of given level and above.
+func (logger *Logger) AddSink(sink Sink, level Level) {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+	logger.core.sinks = append(logger.core.sinks, logSink{sink, level})
+}
+
+// Returns a logger adding fields to every record. It shares the sinks.
+func (logger *Logger) With(fields ...Field) *Logger {
+	all := append(append([]Field(nil), logger.fields...), fields...)
+	return &Logger{core: logger.core, fields: all}
+}
+
+// Reports whether any sink receives records of given level.
+func (logger *Logger) Enabled(level Level) bool {
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+	for _, s := range logger.core.sinks {
+		if level >= s.level && s.level != LevelOff {
+			return true
+		}
+	}
+	return false
+}
+
+// Sends a record to all sinks accepting its level. Sink errors are
+// reported to stderr since they can not be logged.
+func (logger *Logger) Log(level Level, msg string, fields ...Field) {
+	r := &Record{
+		Time:    time.Now(),
+		Level:   level,
+		Message: msg,
+		Fields:  append(append([]Field(nil), logger.fields...), fields...),
+	}
+
+	logger.core.mu.Lock()
+	defer logger.core.mu.Unlock()
+	for _, s := range logger.core.sinks {
+		if level < s.level || s.level == LevelOff {
+			continue
+		}
+		if err := s.sink.Write(r); err != nil {
+			fmt.Fprintf(os.Stderr, "log: %v\n", err)
+		}
+	}
+}
+
+// Logs a message with debug level.
+func (logger *Logger) Debug(msg string, fields ...Field) {
+	logger.Log(LevelDebug, msg, fields...)
+}
+
+// Logs a message with info level.
+func (logger *Logger) Info(msg string, fields ...Field) {
+	logger.Log(LevelInfo, msg, fields...)
+}
+
+// Logs a message with warn level.
+func (logger *Logger) Warn(msg string, fields ...Field) {
+	logger.Log(LevelWarn, msg, fields...)
+}
+
+// Logs a message with error level.
+func (logger *Logger) Error(msg string, fields ...Field) {
+	logger.Log(LevelError, msg, fields...)
+}
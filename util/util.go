@@ -3,13 +3,19 @@
 package util
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
-	//"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 // Generic error checking function.
@@ -21,12 +27,12 @@ func Check(err error) {
 
 // Alias to log.Fatalf function.
 func Fatalf(format string, v ...interface{}) {
-	log.Fatalf(format, v)
+	log.Fatalf(format, v...)
 }
 
 // Alias to log.Fatal function.
 func Fatal(v ...interface{}) {
-	log.Fatal(v)
+	log.Fatal(v...)
 }
 
 // Kills process and exits.
@@ -60,13 +66,117 @@ func ReadFile(filename string) []byte {
 }
 
 // Exports given data to XML file.
-func ExportXML(filename string) {
+func ExportXML(filename string, v interface{}) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
 
+	data = append([]byte(xml.Header), data...)
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
 }
 
 // Imports data from the given XML file.
-func ImportXML(filename string) {
+func ImportXML(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return xml.Unmarshal(data, v)
+}
+
+// Exports given data to JSON file.
+func ExportJSON(filename string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
 
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
+
+// Imports data from the given JSON file.
+func ImportJSON(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// Exports given data to YAML file.
+func ExportYAML(filename string, v interface{}) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// Imports data from the given YAML file.
+func ImportYAML(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, v)
+}
+
+// Exports given data to TOML file.
+func ExportTOML(filename string, v interface{}) error {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// Imports data from the given TOML file.
+func ImportTOML(filename string, v interface{}) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	return toml.Unmarshal(data, v)
+}
+
+// Exports given data to a file, the format is picked by file extension.
+func ExportFile(filename string, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xml":
+		return ExportXML(filename, v)
+	case ".json":
+		return ExportJSON(filename, v)
+	case ".yaml", ".yml":
+		return ExportYAML(filename, v)
+	case ".toml":
+		return ExportTOML(filename, v)
+	}
+
+	return fmt.Errorf("unsupported file format: %s", filename)
+}
+
+// Imports data from a file, the format is picked by file extension.
+// The same layout is used for XML, JSON, YAML and TOML files.
+func ImportFile(filename string, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xml":
+		return ImportXML(filename, v)
+	case ".json":
+		return ImportJSON(filename, v)
+	case ".yaml", ".yml":
+		return ImportYAML(filename, v)
+	case ".toml":
+		return ImportTOML(filename, v)
+	}
+
+	return fmt.Errorf("unsupported file format: %s", filename)
 }
 
 // Reports whether substr is within the string.
@@ -98,6 +208,12 @@ func StrToUint8(str string) uint8 {
 	return uint8(val)
 }
 
+// Converts binary string to unsigned integer.
+func BitsToUint8(str string) uint8 {
+	val, _ := strconv.ParseUint(str, 2, 8)
+	return uint8(val)
+}
+
 // Converts string to unsigned integer.
 func StrToUint16(str string) uint16 {
 	val, _ := strconv.ParseUint(str, 0, 16)
@@ -122,16 +238,6 @@ func FmtBits(x uint8) string {
 	return fmt.Sprintf("%08b", bx)
 }
 
-// Shows formatted representation of opts from bits.
-func FmtOptStr(rx, tx, ledx, invert, hw_flow string) string {
-	return fmt.Sprintf("%s%s%s000%s%s", rx, tx, ledx, invert, hw_flow)
-}
-
-// Shows formatted representation of pins from bits.
-func FmtPinStr(suspend, usbcfg, rx, tx string) string {
-	return fmt.Sprintf("%s%s00%s%s00", suspend, usbcfg, rx, tx)
-}
-
 // Finds the bit at given position in a byte.
 func FindBit(x uint8, pos int) string {
 	var bit string
@@ -160,17 +266,17 @@ func FindBit(x uint8, pos int) string {
 
 // Finds the bit at given position in a byte.
 func GetBit(x uint8, pos int) string {
-	return Uint8ToStr((x >> uint8(pos)) & 1)
+	return fmt.Sprintf("%d", (x>>uint8(pos))&1)
 }
 
 // Concatenates given bytes.
 func ConcatBytes(high, low uint8) uint16 {
-	return uint16((high << 8) | low)
+	return uint16(high)<<8 | uint16(low)
 }
 
 // Splits given uint16 value to uint8 values.
 func SplitBytes(val uint16) (high, low uint8) {
-	high = uint8(val & 0xff)
-	low = uint8((val >> 8))
+	high = uint8(val >> 8)
+	low = uint8(val & 0xff)
 	return
 }